@@ -98,6 +98,7 @@ func (svc *Service) content(next HandlerFunc) HandlerFunc {
 			if ext := PathExt(ctx.Request.URL.Path); ext != "" {
 				// remove extension from path.
 				ctx.Request.URL.Path = strings.TrimSuffix(ctx.Request.URL.Path, ext)
+				ctx.Request.URL.RawPath = strings.TrimSuffix(ctx.Request.URL.RawPath, ext)
 				// create vendor media type and fallthrough
 				accept = Content.Mediatype + "+" + ext[1:]
 			}
@@ -43,6 +43,10 @@ type Context struct {
 	//		ctx.PathValues.Get("username") // returns the first value for "username"
 	//		ctx.PathValues.Get("_2")       // values are also accessible by index
 	//		ctx.PathValues["colors"]       // if more than one color value.
+	//		ctx.PathValues.Get(RoutePattern) // the route matched, "/users/{word:username}"
+	//
+	// The map is reused by other requests after this one is done, so it must not
+	// be kept past the request; e.g., in a goroutine. Copy the values instead.
 	//
 	// See also: Router, url.Values
 	PathValues url.Values
@@ -71,6 +75,12 @@ var contextPool = sync.Pool{
 	New: func() interface{} { return new(Context) },
 }
 
+// pathValuesPool allows us to reuse the PathValues maps of requests, so the
+// routing doesn't allocate them for every request.
+var pathValuesPool = sync.Pool{
+	New: func() interface{} { return make(url.Values) },
+}
+
 // newContext returns a new Context object.
 // This function will alter Request.URL, adding scheme and host:port as provided by the client.
 func newContext(parent context.Context, w http.ResponseWriter, r *http.Request) *Context {
@@ -88,6 +98,12 @@ func (ctx *Context) free() {
 	ctx.wroteHeader = false
 	ctx.status = 0
 	ctx.bytes = 0
+	if ctx.PathValues != nil {
+		for k := range ctx.PathValues {
+			delete(ctx.PathValues, k)
+		}
+		pathValuesPool.Put(ctx.PathValues)
+	}
 	ctx.PathValues = nil
 	ctx.Decode = nil
 	ctx.Encode = nil
@@ -96,6 +112,7 @@ func (ctx *Context) free() {
 
 // Clone returns a shallow cloned context using 'w', an http.ResponseWriter object.
 // If 'w' is nil, the ResponseWriter value can be assigned after cloning.
+// The clone shares PathValues with 'ctx', which is reused when 'ctx' is done.
 func (ctx *Context) Clone(w http.ResponseWriter) *Context {
 	clone := contextPool.Get().(*Context)
 	clone.Context = ctx.Context
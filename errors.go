@@ -22,4 +22,16 @@ type StatusError struct {
 // StatusError implements the error interface.
 func (e *StatusError) Error() string { return e.Message }
 
+// Is returns true if 'target' is a StatusError with the same Code and Message,
+// so errors.Is matches a copy of an error with other Details to the original;
+// e.g., the ErrRouteBadMethod copies of TrieRegexpRouter:
+//
+//	if errors.Is(err, relax.ErrRouteBadMethod) {
+//		// ...
+//	}
+func (e *StatusError) Is(target error) bool {
+	t, ok := target.(*StatusError)
+	return ok && t.Code == e.Code && t.Message == e.Message
+}
+
 // BUG(TODO): StatusError is too shallow, need to implement better error system with locale support.
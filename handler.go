@@ -0,0 +1,275 @@
+// Copyright 2014-present Codehack. All rights reserved.
+// For mobile and web development visit http://codehack.com
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package relax
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// contextKey is the type of the keys of values in the context of a request.
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string { return "relax context value " + k.name }
+
+// PathValuesKey is the key of the path values, url.Values, in the context of a
+// request served by RouterHandler, or passed to WithPathValues.
+var PathValuesKey = &contextKey{"path-values"}
+
+// WithPathValues returns a shallow copy of the request 'r' with the path
+// values 'values' in its context, for PathValue and PathValues.
+func WithPathValues(r *http.Request, values url.Values) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), PathValuesKey, values))
+}
+
+// PathValues returns the path values in the context of the request 'r', or nil
+// if there are none.
+//
+// The values of a request served by RouterHandler are reused by other requests
+// after this one is done, so they must not be kept past the request.
+func PathValues(r *http.Request) url.Values {
+	values, _ := r.Context().Value(PathValuesKey).(url.Values)
+	return values
+}
+
+// PathValue returns the first path value of the PSE 'name' in the context of
+// the request 'r', or "" if there is none. Values are also accessible by index,
+// as "_1", "_2".
+//
+//	http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//		log.Println("user", relax.PathValue(r, "username"))
+//	})
+func PathValue(r *http.Request, name string) string {
+	return PathValues(r).Get(name)
+}
+
+/*
+RouterHandler is an http.Handler that serves requests with the routes of a
+Router, to use the routing engine with net/http without a Service.
+
+	router := relax.NewRouter()
+	router.AddRoute("GET", "/users/{uint:id}", handler)
+	log.Fatal(http.ListenAndServe(":8000", &relax.RouterHandler{Router: router}))
+
+The handler of the route matched is called with a Context that has the path
+values in PathValues, and the request with the values in its context; so
+middleware that knows nothing of relax can read them with PathValue. Errors
+of the router are answered as a Service does: with the status code and message
+of the StatusError, the Allow header of a 405, and the Location header of a
+redirect. There are no filters nor content negotiation.
+*/
+type RouterHandler struct {
+	// Router has the routes served.
+	Router Router
+
+	// Encoder encodes the responses, and decodes the requests, of the handlers.
+	// Default: JSON, as NewEncoder
+	Encoder Encoder
+}
+
+// defaultEncoder is the encoder of a RouterHandler without one.
+var defaultEncoder = NewEncoder()
+
+// ServeHTTP implements http.Handler. It finds the handler of the request's
+// method and path in the router, and calls it; or responds with the router's
+// error.
+func (h *RouterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	enc := h.Encoder
+	if enc == nil {
+		enc = defaultEncoder
+	}
+	path := routePath(h.Router, r)
+	values := pathValuesPool.Get().(url.Values)
+	handler, err := h.Router.FindHandler(r.Method, path, &values)
+	if err == nil {
+		r = WithPathValues(r, values)
+	}
+
+	ctx := newContext(r.Context(), w, r)
+	defer ctx.free()
+	ctx.PathValues = values
+	ctx.Encode = enc.Encode
+	ctx.Decode = enc.Decode
+	if err != nil {
+		ctx.Header().Set("Content-Type", enc.ContentType())
+		routeError(ctx, h.Router, path, err)
+		return
+	}
+	handler(ctx)
+}
+
+// Param is a path value: the varname of a PSE and the value matched. It is as
+// the Param of julienschmidt/httprouter, to port handlers from it.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params are the path values of a route, in the order of the path. Values
+// without a varname have their index key; e.g., "_1".
+type Params []Param
+
+// ByName returns the value of the first Param with the key 'name', or "" if
+// there is none.
+func (ps Params) ByName(name string) string {
+	for i := range ps {
+		if ps[i].Key == name {
+			return ps[i].Value
+		}
+	}
+	return ""
+}
+
+// NewParams returns the Params of the path values 'values', as FindHandler
+// stores them. The values are ordered by their index, and named by the PSE's
+// of the route matched, RoutePattern; the default values of absent optional
+// segments are last. RoutePattern is not a Param.
+//
+//	ps := relax.NewParams(ctx.PathValues)
+//	id := ps.ByName("id")
+func NewParams(values url.Values) Params {
+	names := routeParamNames(values.Get(RoutePattern))
+	ps := make(Params, 0, len(values))
+	used := make(map[string]int, len(names))
+	for n := 1; ; n++ {
+		key := "_" + strconv.Itoa(n)
+		v, ok := values[key]
+		if !ok || len(v) == 0 {
+			break
+		}
+		if n <= len(names) && names[n-1] != "" {
+			key = names[n-1]
+			used[key]++
+		}
+		ps = append(ps, Param{key, v[0]})
+	}
+	var rest []string
+	for k := range values {
+		if k != RoutePattern && !isIndexKey(k) && used[k] < len(values[k]) {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, name := range rest {
+		for _, v := range values[name][used[name]:] {
+			ps = append(ps, Param{name, v})
+		}
+	}
+	return ps
+}
+
+// paramNames caches the names of routeParamNames by route pattern.
+var paramNames sync.Map
+
+// routeParamNames returns the varnames of the path values of the route
+// 'pattern' by index, as FindHandler stores them; "" for a value without
+// varname, like a group of a custom regexp. The names are taken from the
+// value groups of the route's PSE's, so the regexp's are compiled once.
+func routeParamNames(pattern string) []string {
+	if pattern == "" {
+		return nil
+	}
+	if names, ok := paramNames.Load(pattern); ok {
+		return names.([]string)
+	}
+	var names []string
+	add := func(rx *regexp.Regexp, err error) {
+		if err != nil {
+			return
+		}
+		for _, g := range valueGroups(rx)[1:] {
+			if g.value {
+				names = append(names, g.name)
+			}
+		}
+	}
+	host, path := splitHost(pattern)
+	if strings.ContainsAny(host, "{*") {
+		add(compileHost(host))
+	}
+	for _, pseg := range pathSegments("", path)[1:] {
+		pse, _, _, _ := optionalSegment(pseg)
+		if (strings.Contains(pse, "{") && strings.Contains(pse, "}")) || strings.Contains(pse, "*") {
+			add(compileSegment(pse))
+		}
+	}
+	paramNames.Store(pattern, names)
+	return names
+}
+
+// isIndexKey returns true if 'key' is the key of a path value by index, "_n".
+func isIndexKey(key string) bool {
+	if len(key) < 2 || key[0] != '_' {
+		return false
+	}
+	for i := 1; i < len(key); i++ {
+		if key[i] < '0' || key[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// mountMethods are the methods of the routes of a mounted handler.
+var mountMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+/*
+Mount delegates the paths below 'prefix' to the handler 'h', for all methods,
+with a {path:varname} route under the prefix. The handler is called with the
+prefix stripped from the request URL, as http.StripPrefix does; and with the
+path values in the request context, see PathValue.
+
+	router.Mount("/static", http.FileServer(http.Dir("public")))
+	// "/static/css/app.css" is served as "/css/app.css"
+
+The prefix may have PSE's and a host, as any route. The prefix itself, without
+the rest, is served as "/". The varname of the rest is "rest".
+*/
+func (router *TrieRegexpRouter) Mount(prefix string, h http.Handler) {
+	path := prefix
+	if host, rest := splitHost(prefix); host != "" {
+		path = rest
+	}
+	n := len(pathSegments("", path)) - 1
+	route := strings.TrimRight(prefix, "/") + "/{path:rest?}"
+	handler := func(ctx *Context) {
+		r := WithPathValues(ctx.Request, ctx.PathValues)
+		r.URL = new(url.URL)
+		*r.URL = *ctx.Request.URL
+		r.URL.Path = stripSegments(r.URL.Path, n)
+		if r.URL.RawPath != "" {
+			r.URL.RawPath = stripSegments(r.URL.RawPath, n)
+		}
+		h.ServeHTTP(ctx, r)
+	}
+	router.lock()
+	defer router.mu.Unlock()
+	for _, method := range mountMethods {
+		router.mustAddRoute(method, route, handler)
+	}
+}
+
+// stripSegments returns 'path' without its first 'n' segments, ignoring empty
+// segments as the router does. The path returned begins with "/".
+func stripSegments(path string, n int) string {
+	for ; n > 0; n-- {
+		path = strings.TrimLeft(path, "/")
+		i := strings.Index(path, "/")
+		if i == -1 {
+			return "/"
+		}
+		path = path[i:]
+	}
+	return "/" + strings.TrimLeft(path, "/")
+}
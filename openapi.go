@@ -0,0 +1,168 @@
+// Copyright 2014-present Codehack. All rights reserved.
+// For mobile and web development visit http://codehack.com
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package relax
+
+import (
+	"strconv"
+	"strings"
+)
+
+// OpenAPIPaths is the "paths" object of an OpenAPI 3 document, with the path
+// items by path. It can be encoded to JSON as is.
+// See https://spec.openapis.org/oas/v3.0.3#paths-object
+type OpenAPIPaths map[string]OpenAPIPathItem
+
+// OpenAPIPathItem has the operations of a path by HTTP method, in lower case;
+// e.g., "get", "put".
+type OpenAPIPathItem map[string]*OpenAPIOperation
+
+// OpenAPIOperation describes the operation of a route. Responses has only a
+// default response, since the router doesn't know the responses.
+type OpenAPIOperation struct {
+	Parameters []OpenAPIParameter         `json:"parameters,omitempty"`
+	Responses  map[string]OpenAPIResponse `json:"responses"`
+}
+
+// OpenAPIParameter describes a path parameter, a PSE of a route.
+type OpenAPIParameter struct {
+	Name     string        `json:"name"`
+	In       string        `json:"in"`
+	Required bool          `json:"required"`
+	Schema   OpenAPISchema `json:"schema"`
+}
+
+// OpenAPISchema is the schema of a path parameter, inferred from its PSE type.
+type OpenAPISchema struct {
+	Type    string   `json:"type"`
+	Format  string   `json:"format,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	Enum    []string `json:"enum,omitempty"`
+	Minimum *int64   `json:"minimum,omitempty"`
+	Maximum *int64   `json:"maximum,omitempty"`
+}
+
+// OpenAPIResponse describes a response of an operation.
+type OpenAPIResponse struct {
+	Description string `json:"description"`
+}
+
+// openAPISchemas are the schemas of the PSE types that are not strings without
+// format. The range of int and uint is added from the PSE.
+var openAPISchemas = map[string]OpenAPISchema{
+	"uint":       {Type: "integer"},
+	"int":        {Type: "integer"},
+	"port":       {Type: "integer"},
+	"timestamp":  {Type: "integer", Format: "int64"},
+	"float":      {Type: "number"},
+	"sci":        {Type: "number"},
+	"bool":       {Type: "boolean"},
+	"uuid":       {Type: "string", Format: "uuid"},
+	"date":       {Type: "string", Format: "date"},
+	"datestrict": {Type: "string", Format: "date"},
+	"time":       {Type: "string", Format: "time"},
+	"duration":   {Type: "string", Format: "duration"},
+	"ipv4":       {Type: "string", Format: "ipv4"},
+	"ipv6":       {Type: "string", Format: "ipv6"},
+	"email":      {Type: "string", Format: "email"},
+	"hostname":   {Type: "string", Format: "hostname"},
+	"base64":     {Type: "string", Format: "byte"},
+}
+
+/*
+OpenAPIPaths returns the routes of the router as the "paths" object of an
+OpenAPI 3 document, to begin a spec of the service. The PSE's of each route
+are path parameters, with a schema from the PSE type:
+
+	{uint:id}                  -> integer, minimum 0
+	{int:t(-40,85)}            -> integer, minimum -40, maximum 85
+	{uuid:id}                  -> string, format uuid
+	{date:day}                 -> string, format date
+	{enum:period:daily|weekly} -> string, enum [daily, weekly]
+	{re:[a-z]+}                -> string, pattern [a-z]+
+	{rei:[a-z]+}               -> string, pattern (?i)[a-z]+
+
+Other PSE types are strings. A route with an optional PSE is listed with and
+without it. Host routes are not listed, since OpenAPI paths don't have hosts.
+*/
+func (router *TrieRegexpRouter) OpenAPIPaths() OpenAPIPaths {
+	paths := make(OpenAPIPaths)
+	router.Walk(func(method, pattern string, handler HandlerFunc) bool {
+		if strings.HasPrefix(pattern, "//") {
+			return true
+		}
+		pseg := strings.Split(pattern[1:], "/")
+		if pse, _, _, ok := optionalSegment(pseg[len(pseg)-1]); ok {
+			openAPIAdd(paths, method, pseg[:len(pseg)-1])
+			pseg[len(pseg)-1] = pse
+		}
+		openAPIAdd(paths, method, pseg)
+		return true
+	})
+	return paths
+}
+
+// openAPIAdd adds the operation of 'method' for the route with the path
+// segments 'pseg' to paths.
+func openAPIAdd(paths OpenAPIPaths, method string, pseg []string) {
+	op := &OpenAPIOperation{
+		Responses: map[string]OpenAPIResponse{"default": {Description: "Response"}},
+	}
+	segs := make([]string, len(pseg))
+	for i := range pseg {
+		text, pses := splitPSE(pseg[i])
+		seg := text[0]
+		for j, pse := range pses {
+			name := pseName(pse)
+			if name == "" {
+				name = "_" + strconv.Itoa(len(op.Parameters)+1)
+			}
+			op.Parameters = append(op.Parameters, OpenAPIParameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   openAPISchema(pse),
+			})
+			seg += "{" + name + "}" + text[j+1]
+		}
+		segs[i] = seg
+	}
+	path := "/" + strings.Join(segs, "/")
+	if paths[path] == nil {
+		paths[path] = make(OpenAPIPathItem)
+	}
+	paths[path][strings.ToLower(method)] = op
+}
+
+// openAPISchema returns the schema of the values of the PSE 'pse'.
+func openAPISchema(pse string) OpenAPISchema {
+	typ := "string"
+	if m := pseTypeExp.FindStringSubmatch(pse); m != nil && strings.HasPrefix(pse, m[0]) {
+		typ = m[1]
+	}
+	spec := strings.TrimSuffix(strings.TrimPrefix(pse, "{"+typ+":"), "}")
+	switch typ {
+	case "enum":
+		if i := strings.Index(spec, ":"); i != -1 {
+			return OpenAPISchema{Type: "string", Enum: strings.Split(spec[i+1:], "|")}
+		}
+	case "re":
+		return OpenAPISchema{Type: "string", Pattern: spec}
+	case "rei":
+		return OpenAPISchema{Type: "string", Pattern: "(?i)" + spec}
+	case "int", "uint":
+		schema := openAPISchemas[typ]
+		if _, min, max, ok := rangeSpec(spec); ok {
+			schema.Minimum, schema.Maximum = &min, &max
+		} else if typ == "uint" {
+			schema.Minimum = new(int64)
+		}
+		return schema
+	}
+	if schema, ok := openAPISchemas[typ]; ok {
+		return schema
+	}
+	return OpenAPISchema{Type: "string"}
+}
@@ -0,0 +1,58 @@
+// Copyright 2014-present Codehack. All rights reserved.
+// For mobile and web development visit http://codehack.com
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package relax_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codehack/go-relax"
+)
+
+// TestRouterOptions sets the options of the router of a service from outside
+// the package, and checks the responses.
+func TestRouterOptions(t *testing.T) {
+	ok := func(ctx *relax.Context) { ctx.Respond("ok") }
+	var tests = []struct {
+		Option   string
+		Set      func(router *relax.TrieRegexpRouter)
+		Path     string
+		Code     int
+		Location string
+	}{
+		{"RedirectTrailingSlash", func(r *relax.TrieRegexpRouter) { r.RedirectTrailingSlash = true }, "/users/", http.StatusMovedPermanently, "/users"},
+		{"RedirectCleanPath", func(r *relax.TrieRegexpRouter) { r.RedirectCleanPath = true }, "/users//5", http.StatusMovedPermanently, "/users/5"},
+		{"CleanDotSegments", func(r *relax.TrieRegexpRouter) { r.CleanDotSegments = true }, "/files/../users", http.StatusOK, ""},
+		{"CaseInsensitive", func(r *relax.TrieRegexpRouter) { r.CaseInsensitive = true }, "/USERS", http.StatusOK, ""},
+		{"CombineRegexps", func(r *relax.TrieRegexpRouter) { r.CombineRegexps = true }, "/users/bob", http.StatusOK, ""},
+		{"MaxSegmentLength", func(r *relax.TrieRegexpRouter) { r.MaxSegmentLength = 8 }, "/users/" + strings.Repeat("a", 9), http.StatusNotFound, ""},
+		{"MaxPathLength", func(r *relax.TrieRegexpRouter) { r.MaxPathLength = 16 }, "/users/" + strings.Repeat("a", 10), http.StatusRequestURITooLong, ""},
+		{"MaxSegments", func(r *relax.TrieRegexpRouter) { r.MaxSegments = 2 }, "/users/5/posts", http.StatusRequestURITooLong, ""},
+	}
+	for _, test := range tests {
+		svc := relax.NewService("/")
+		router, isTrie := svc.Router().(*relax.TrieRegexpRouter)
+		if !isTrie {
+			t.Fatalf("expected the service router to be a TrieRegexpRouter, got %T", svc.Router())
+		}
+		// options that change how routes are added are set first.
+		test.Set(router)
+		router.AddRoute("GET", "/users", ok)
+		router.AddRoute("GET", "/users/{uint:id}", ok)
+		router.AddRoute("GET", "/users/{word:name}", ok)
+
+		w := httptest.NewRecorder()
+		svc.ServeHTTP(w, httptest.NewRequest("GET", test.Path, nil))
+		if w.Code != test.Code {
+			t.Errorf("%s: %s: expected status %d, got %d", test.Option, test.Path, test.Code, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != test.Location {
+			t.Errorf("%s: %s: expected location %q, got %q", test.Option, test.Path, test.Location, loc)
+		}
+	}
+}
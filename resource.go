@@ -85,7 +85,8 @@ func (r *Resource) NotImplemented(ctx *Context) {
 //		// Route "PATCH /users/profile" => 405 Method Not Allowed
 //		users.PATCH("profile", users.MethodNotAllowed)
 func (r *Resource) MethodNotAllowed(ctx *Context) {
-	ctx.Header().Set("Allow", r.service.router.PathMethods(ctx.Request.URL.Path))
+	router := r.service.router
+	ctx.Header().Set("Allow", router.PathMethods(routePath(router, ctx.Request)))
 	ctx.Error(http.StatusMethodNotAllowed, "The method "+ctx.Request.Method+" is not allowed.")
 }
 
@@ -93,7 +94,8 @@ func (r *Resource) MethodNotAllowed(ctx *Context) {
 // the methods allowed for an URI. If the URI is the Service's path then it returns information
 // about the service.
 func (r *Resource) OptionsHandler(ctx *Context) {
-	methods := r.service.router.PathMethods(ctx.Request.URL.Path)
+	router := r.service.router
+	methods := router.PathMethods(routePath(router, ctx.Request))
 	ctx.Header().Set("Allow", methods)
 	if strings.Contains(methods, "PATCH") {
 		// FIXME: this is wrong! perhaps we need Patch.ContentType() or even Service.encoders keys.
@@ -6,48 +6,119 @@
 package relax
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /*
 Router defines the routing system. Objects that implement it have functions
 that add routes, find a handle to resources and provide information about routes.
 
-Relax's default router is trieRegexpRouter. It takes full routes, with HTTP method and path, and
+Relax's default router is TrieRegexpRouter. It takes full routes, with HTTP method and path, and
 inserts them in a trie that can use regular expressions to match individual path segments.
 
-PSE: trieRegexpRouter's path segment expressions (PSE) are match strings that are pre-compiled as
+PSE: TrieRegexpRouter's path segment expressions (PSE) are match strings that are pre-compiled as
 regular expressions. PSE's provide a simple layer of security when accepting values from
 the path. Each PSE is made out of a {type:varname} format, where type is the expected type
 for a value and varname is the name to give the variable that matches the value.
 
 	"{word:varname}" // matches any word; alphanumeric and underscore.
 
-	"{uint:varname}" // matches an unsigned integer.
+	"{uword:varname}" // matches any Unicode word; letters, digits and underscore.
+
+	"{token:varname}" // matches a word that may have dots and hyphens; e.g., report.v2-final.
+
+	"{alpha:varname}" // matches letters only.
+
+	"{alphanum:varname}" // matches letters and digits; no underscore.
+
+	"{uint:varname}" // matches an unsigned integer, of any length; the handler checks overflow.
 
 	"{int:varname}" // matches a signed integer.
 
-	"{float:varname}" // matches a floating-point number in decimal notation.
+	"{int:varname(1,100)}" // matches an integer in the inclusive range; uint too.
+
+	"{word:varname(3,6)}" // matches a word of 3 to 6 chars; also (n) and (min,).
+
+	"{float:varname}" // matches a number in decimal notation, with optional fraction; e.g., 100 or -3.5.
+
+	"{sci:varname}" // matches a number in scientific notation, with optional exponent; e.g., 1.5e-3.
 
 	"{date:varname}" // matches a date in ISO 8601 format.
 
+	"{datestrict:varname}" // matches a calendar date, YYYY-MM-DD, that exists; e.g., no Feb 30.
+
 	"{geo:varname}" // matches a geo location as described in RFC 5870
 
-	"{hex:varname}" // matches a hex number, with optional "0x" prefix.
+	"{time:varname}" // matches a clock time; HH:MM or HH:MM:SS.
+
+	"{timestamp:varname}" // matches Unix time in seconds; up to 11 digits.
+
+	"{duration:varname}" // matches a duration in ISO 8601 format; e.g., P1DT2H.
+
+	"{hex:varname}" // matches a hex number, with optional "0x" prefix; a length as in word, e.g. (32).
 
 	"{uuid:varname}" // matches an UUID.
 
+	"{uuid:varname:4}" // matches an UUID of a version, 1 to 8, with the RFC 4122 variant.
+
+	"{colorhex:varname}" // matches a hex color; RGB, RRGGBB or RRGGBBAA, with optional "#".
+
+	"{port:varname}" // matches a TCP/UDP port number, 0-65535.
+
+	"{bool:varname}" // matches a boolean literal: true/false, yes/no, on/off, 1/0.
+
+	"{email:varname}" // matches an email address; local-part@domain.
+
+	"{hostname:varname}" // matches a DNS host name; e.g., api.example.com.
+
+	"{ipv4:varname}" // matches an IPv4 address in dotted-quad notation.
+
+	"{ipv6:varname}" // matches an IPv6 address, including compressed and IPv4-embedded forms.
+
+	"{ipcidr:varname}" // matches an IPv4 or IPv6 CIDR block; the "/" must be sent as "%2F".
+
+	"{phone:varname}" // matches a phone number in E.164 format; e.g., +14155552671.
+
+	"{mac:varname}" // matches a MAC address (EUI-48) with colons, hyphens or dots.
+
+	"{base64:varname}" // matches a standard base64 string; the "/" must be sent as "%2F".
+
+	"{base64url:varname}" // matches an URL-safe base64 string.
+
+	"{semver:varname}" // matches a semantic version; major.minor.patch[-pre][+build].
+
+	"{objectid:varname}" // matches a MongoDB ObjectID; 24 hex digits.
+
+	"{enum:varname:a|b|c}" // matches one of the literal values listed.
+
+	"{path:varname}" // matches the remaining path, including "/"; must be the last segment.
+
+	"{type:varname?}" // optional segment of any type; must be the last segment.
+
+	"{type:varname=default}" // optional segment with a default value.
+
 	"{varname}" // catch-all; matches anything. it may overlap other matches.
 
 	"*" // translated into "{wild}"
 
 	"{re:pattern}" // custom regexp pattern.
 
-Some sample routes supported by trieRegexpRouter:
+	"{rei:pattern}" // custom regexp pattern, case-insensitive; stored by index, as "_1".
+
+	"{rei:(?P<varname>pattern)}" // as rei, stored by the varname of the named group too.
+
+Some sample routes supported by TrieRegexpRouter:
 
 	GET /api/users/@{word:name}
 
@@ -63,6 +134,56 @@ Some sample routes supported by trieRegexpRouter:
 
 	GET /api/todos/month/{re:([0][1-9]|[1][0-2])}
 
+	GET /api/files/{path:name}
+
+	GET /api/users/{uint:id}/{word:section?}
+
+An optional segment matches the path with and without it, both reach the same
+handler. When the segment is absent its varname is not set in Request.PathValues,
+so PathValues.Get(varname) returns "". Unless a default value is given, which is
+set instead:
+
+	GET /api/reports/{uint:id}/{word:format=json}
+
+PSE values are stored as strings in Request.PathValues, they are converted as needed
+by the handler. The components of a value, like the year of a date, are not
+stored. For example, a {timestamp:from} value into time.Time:
+
+	sec, err := strconv.ParseInt(ctx.PathValues.Get("from"), 10, 64)
+	if err != nil {
+		// ...
+	}
+	from := time.Unix(sec, 0)
+
+GetInt, GetUint, GetFloat, GetUUID, GetDate and GetGeo convert the values of
+those PSE types; e.g., GetDate parses a {date:varname} value into time.Time.
+
+Path segments are percent-decoded after the path is split, so a segment matches and
+stores its decoded value; e.g., "john%20doe" is "john doe". An encoded "/", "%2F",
+is kept in its segment.
+
+Routes may be limited to a host, with the host before the path as in "//host/path".
+Hosts are matched case-insensitive, without the port. Each label of the host, split
+on ".", may have a PSE to store its value in Request.PathValues; custom regexp's are
+not supported. A "*" label matches any subdomains, which are stored in the varname
+"wild". The routes of a matching host are tried before the routes without a host:
+
+	GET //admin.example.com/api/stats
+
+	GET //{word:tenant}.example.com/api/stats
+
+	GET //*.example.com/api/stats
+
+When more than one PSE can match a path segment, string segments are tried first,
+then PSE's in order of specificity: enum; structured types like uuid, date and ipv4;
+bool, port and timestamp; uint; int; float; sci; hex and alpha; alphanum and word;
+uword, token and hostname; base64, base64url and custom regexp's; and the catch-all
+last. PSE's of the same type are tried in the order they were added. For example,
+"/api/users/123" matches "/api/users/{uint:id}" over "/api/users/{word:name}".
+If the rest of the path doesn't match below the segment tried, the next one is
+tried; so "/api/users/123/likes" matches "/api/users/{word:name}/likes" if
+there's no "/api/users/{uint:id}/likes" route.
+
 Since PSE's are compiled to regexp, care must be taken to escape characters that
 might break the compilation.
 */
@@ -89,57 +210,805 @@ var (
 	ErrRouteNotFound = &StatusError{http.StatusNotFound, "That route was not found.", nil}
 
 	// ErrRouteBadMethod is returned when the path did not match a given HTTP method.
+	// TrieRegexpRouter returns a copy with Details set to the Allow header value,
+	// so it's matched with errors.Is(err, ErrRouteBadMethod); see StatusError.Is.
 	ErrRouteBadMethod = &StatusError{http.StatusMethodNotAllowed, "That method is not supported", nil}
+
+	// ErrRouteTooLong is returned when the path is over the limits of the router.
+	// See TrieRegexpRouter.MaxPathLength
+	ErrRouteTooLong = &StatusError{http.StatusRequestURITooLong, "That path is too long.", nil}
+
+	// ErrRouteBadPath is returned when the path has a NUL byte or another
+	// control character, escaped or not.
+	ErrRouteBadPath = &StatusError{http.StatusBadRequest, "That path has invalid characters.", nil}
 )
 
-// pathRegexpCache is a cache of all compiled regexp's so they can be reused.
-var pathRegexpCache = make(map[string]*regexp.Regexp, 0)
+// badMethodError returns a copy of ErrRouteBadMethod with Details set to
+// 'allow', the methods allowed for the path.
+func badMethodError(allow string) *StatusError {
+	return &StatusError{ErrRouteBadMethod.Code, ErrRouteBadMethod.Message, allow}
+}
+
+// redirectError returns a StatusError to redirect a request to 'path'. The status
+// is 301-"Moved Permanently" for GET and HEAD requests, and 308-"Permanent Redirect"
+// otherwise so the method is kept. Details is set to the path.
+func redirectError(method, path string) *StatusError {
+	code := http.StatusPermanentRedirect
+	if method == "GET" || method == "HEAD" {
+		code = http.StatusMovedPermanently
+	}
+	return &StatusError{code, "That route has moved.", path}
+}
+
+// RoutePattern is the key in the path values of the pattern of the matched
+// route, as it was given to AddRoute; e.g., a request for "/api/users/5" has
+// the pattern "/api/users/{uint:id}". It is useful to log and count requests
+// by route. The key is not a valid varname, so it can't be used by a PSE.
+//
+//	ctx.PathValues.Get(relax.RoutePattern)
+const RoutePattern = "route.pattern"
+
+// labelExp is a DNS label; ipv4Exp and ipv6Exp are the address expressions
+// used by the ip PSE's.
+const (
+	labelExp = `[[:alnum:]](?:[[:alnum:]\-]{0,61}[[:alnum:]])?`
+	ipv4Exp  = `(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)`
+	ipv6Exp  = `(?:` +
+		`(?:[[:xdigit:]]{1,4}:){7}[[:xdigit:]]{1,4}|` +
+		`(?:[[:xdigit:]]{1,4}:){1,7}:|` +
+		`(?:[[:xdigit:]]{1,4}:){1,6}:[[:xdigit:]]{1,4}|` +
+		`(?:[[:xdigit:]]{1,4}:){1,5}(?::[[:xdigit:]]{1,4}){1,2}|` +
+		`(?:[[:xdigit:]]{1,4}:){1,4}(?::[[:xdigit:]]{1,4}){1,3}|` +
+		`(?:[[:xdigit:]]{1,4}:){1,3}(?::[[:xdigit:]]{1,4}){1,4}|` +
+		`(?:[[:xdigit:]]{1,4}:){1,2}(?::[[:xdigit:]]{1,4}){1,5}|` +
+		`[[:xdigit:]]{1,4}:(?::[[:xdigit:]]{1,4}){1,6}|` +
+		`:(?:(?::[[:xdigit:]]{1,4}){1,7}|:)|` +
+		`(?i:fe80):(?::[[:xdigit:]]{0,4}){0,4}%[\w.\-]+|` +
+		`::(?:(?i:ffff)(?::0{1,4})?:)?` + ipv4Exp + `|` +
+		`(?:[[:xdigit:]]{1,4}:){1,4}:` + ipv4Exp +
+		`)`
+)
+
+// base64Exp returns an expression that matches base64 data using the alphabet
+// in class. The length must be consistent with 4-char blocks, the last block may
+// omit its "=" padding.
+func base64Exp(class string) string {
+	return fmt.Sprintf(`(?:%[1]s{4})*(?:%[1]s{4}|%[1]s{3}=?|%[1]s{2}(?:==)?)`, class)
+}
+
+// rangeSpec splits a "varname(min,max)" spec into its parts. ok is false if
+// there is no range. It will panic if min is greater than max.
+func rangeSpec(spec string) (name string, min, max int64, ok bool) {
+	i := strings.Index(spec, "(")
+	if i == -1 {
+		return spec, 0, 0, false
+	}
+	name = spec[:i]
+	bounds := strings.Split(spec[i+1:len(spec)-1], ",")
+	if len(bounds) != 2 {
+		panic("relax: PSE range is not valid, expected (min,max): " + spec)
+	}
+	min, err1 := strconv.ParseInt(bounds[0], 10, 64)
+	max, err2 := strconv.ParseInt(bounds[1], 10, 64)
+	if err1 != nil || err2 != nil || min > max {
+		panic("relax: PSE range is not valid, expected (min,max): " + spec)
+	}
+	return name, min, max, true
+}
+
+// lengthSpec splits a "varname(min,max)" spec into the name and a regexp
+// repetition for the length; "+" if there is no length. It will panic if the
+// length is not valid.
+func lengthSpec(spec string) (name, rep string) {
+	i := strings.Index(spec, "(")
+	if i == -1 {
+		return spec, "+"
+	}
+	name = spec[:i]
+	bounds := strings.Split(spec[i+1:len(spec)-1], ",")
+	min, err := strconv.Atoi(bounds[0])
+	if err != nil || min < 1 || len(bounds) > 2 {
+		panic("relax: PSE length is not valid, expected (n), (min,) or (min,max): " + spec)
+	}
+	if len(bounds) == 1 {
+		return name, fmt.Sprintf("{%d}", min)
+	}
+	if bounds[1] == "" {
+		return name, fmt.Sprintf("{%d,}", min)
+	}
+	max, err := strconv.Atoi(bounds[1])
+	if err != nil || min > max {
+		panic("relax: PSE length is not valid, expected (n), (min,) or (min,max): " + spec)
+	}
+	return name, fmt.Sprintf("{%d,%d}", min, max)
+}
+
+// rangeExp returns an expression that matches the decimal integers between
+// min and max, inclusive. Positive values may have a "+" sign, none have
+// leading zeros.
+func rangeExp(min, max int64) string {
+	var alts []string
+	if min < 0 {
+		lo := uint64(1)
+		if max < 0 {
+			lo = magnitude(max)
+		}
+		for _, exp := range digitRange(lo, magnitude(min)) {
+			alts = append(alts, `\-`+exp)
+		}
+	}
+	if max >= 0 {
+		lo := uint64(0)
+		if min > 0 {
+			lo = uint64(min)
+		}
+		for _, exp := range digitRange(lo, uint64(max)) {
+			alts = append(alts, `\+?`+exp)
+		}
+	}
+	return `(?:` + strings.Join(alts, "|") + `)`
+}
+
+// magnitude returns the absolute value of the negative 'n'; math.MinInt64 too,
+// which can't be negated as an int64.
+func magnitude(n int64) uint64 {
+	return uint64(-(n + 1)) + 1
+}
+
+// digitRange returns a list of expressions that together match the decimal
+// integers between lo and hi, inclusive.
+func digitRange(lo, hi uint64) []string {
+	a, b := strconv.FormatUint(lo, 10), strconv.FormatUint(hi, 10)
+	if len(a) < len(b) {
+		// split at the powers of 10, so each part has the same number of digits.
+		top, _ := strconv.ParseUint(strings.Repeat("9", len(a)), 10, 64)
+		return append(digitRange(lo, top), digitRange(top+1, hi)...)
+	}
+	return sameDigitRange(a, b)
+}
+
+// sameDigitRange returns expressions that match the numbers between a and b,
+// which have the same number of digits.
+func sameDigitRange(a, b string) []string {
+	if a == b {
+		return []string{a}
+	}
+	if a[0] == b[0] {
+		exps := sameDigitRange(a[1:], b[1:])
+		for i := range exps {
+			exps[i] = a[:1] + exps[i]
+		}
+		return exps
+	}
+	n := len(a) - 1
+	rest := ""
+	if n > 0 {
+		rest = fmt.Sprintf(`\d{%d}`, n)
+	}
+	lo, hi := a[0], b[0]
+	var exps []string
+	if strings.Trim(a[1:], "0") != "" {
+		for _, exp := range sameDigitRange(a[1:], strings.Repeat("9", n)) {
+			exps = append(exps, a[:1]+exp)
+		}
+		lo++
+	}
+	if strings.Trim(b[1:], "9") != "" {
+		for _, exp := range sameDigitRange(strings.Repeat("0", n), b[1:]) {
+			exps = append(exps, b[:1]+exp)
+		}
+		hi--
+	}
+	if lo <= hi {
+		exps = append(exps, fmt.Sprintf(`[%c-%c]`, lo, hi)+rest)
+	}
+	return exps
+}
+
+// regexpCache is a cache of the compiled regexp's of a router so they can be
+// reused. Each router has its own cache.
+// The entries are stored by key, see segmentKey, in a sync.Map; the cache is
+// written only when routes are added, and read on every request, so the reads
+// don't need locks.
+type regexpCache struct {
+	entries sync.Map
+}
+
+// regexpEntry is a compiled regexp in a regexpCache, with its value groups.
+// See valueGroups.
+type regexpEntry struct {
+	exp    string
+	rx     *regexp.Regexp
+	groups []valueGroup
+}
+
+// newRegexpCache returns an empty regexpCache.
+func newRegexpCache() *regexpCache {
+	return new(regexpCache)
+}
+
+// store stores the compiled regexp 'rx', and its value groups, with the key
+// 'exp'. The groups of PSE's of registered types have them, see setTypes.
+func (c *regexpCache) store(exp string, rx *regexp.Regexp) {
+	c.entries.Store(exp, &regexpEntry{exp: exp, rx: rx, groups: setTypes(exp, valueGroups(rx))})
+}
+
+// load returns the compiled regexp with the key 'exp', and its value groups;
+// or nil if it's not in the cache.
+func (c *regexpCache) load(exp string) (*regexp.Regexp, []valueGroup) {
+	v, ok := c.entries.Load(exp)
+	if !ok {
+		return nil, nil
+	}
+	entry := v.(*regexpEntry)
+	return entry.rx, entry.groups
+}
+
+// intern returns the key 'exp' as it was stored, so the nodes of all the
+// routes with the same PSE share one string; or exp if it's not stored.
+func (c *regexpCache) intern(exp string) string {
+	if v, ok := c.entries.Load(exp); ok {
+		return v.(*regexpEntry).exp
+	}
+	return exp
+}
+
+// valueGroup is a group of a compiled regexp, by index. value is true if the
+// group has a value to store in the path values, and name is the group name,
+// as in SubexpNames; so matching doesn't need to get the names. typ, if not
+// nil, is the registered PSE type of the group, whose matcher is checked.
+type valueGroup struct {
+	value bool
+	name  string
+	typ   *pseType
+}
+
+// valueGroups returns the groups of 'rx', by index, with the ones that have
+// values to store in the path values. These are the named groups that are not
+// nested in another named group; the nested ones are components of a value,
+// like the year of a date. And the unnamed groups that are not nested, nor
+// have named groups, like in a custom regexp.
+func valueGroups(rx *regexp.Regexp) []valueGroup {
+	groups := make([]valueGroup, rx.NumSubexp()+1)
+	for i, name := range rx.SubexpNames() {
+		groups[i].name = name
+	}
+	re, err := syntax.Parse(rx.String(), syntax.Perl)
+	if err != nil {
+		return groups
+	}
+	var walk func(re *syntax.Regexp, nested, named bool)
+	walk = func(re *syntax.Regexp, nested, named bool) {
+		if re.Op == syntax.OpCapture {
+			if re.Name != "" {
+				groups[re.Cap].value = !named
+				named = true
+			} else {
+				groups[re.Cap].value = !nested && !hasNamedGroup(re)
+			}
+			nested = true
+		}
+		for _, sub := range re.Sub {
+			walk(sub, nested, named)
+		}
+	}
+	walk(re, false, false)
+	return groups
+}
+
+// setTypes sets the types of the value groups of the PSE's of 'pattern' that
+// are of a registered type; 'groups' are the value groups of the pattern's
+// regexp. It returns groups. The matcher of a type is loaded when a value is
+// matched, so one registered after the route is added is checked too.
+func setTypes(pattern string, groups []valueGroup) []valueGroup {
+	for _, sub := range registeredTypeExp.FindAllStringSubmatch(pattern, -1) {
+		t, ok := registeredType(sub[1])
+		if !ok {
+			continue
+		}
+		for i := range groups {
+			if groups[i].value && groups[i].name == sub[2] {
+				groups[i].typ = t
+			}
+		}
+	}
+	return groups
+}
+
+// hasNamedGroup returns true if 're' has a named group.
+func hasNamedGroup(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpCapture && re.Name != "" {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if hasNamedGroup(sub) {
+			return true
+		}
+	}
+	return false
+}
 
-// trieRegexpRouter implements Router with a trie that can store regular expressions.
+// TrieRegexpRouter implements Router with a trie that can store regular expressions.
+// It's the router of a Service, and of NewRouter. Its options are the exported
+// fields, which are set before the router serves requests:
+//
+//	router := svc.Router().(*relax.TrieRegexpRouter)
+//	router.RedirectTrailingSlash = true
+//
 // root points to the top of the tree from which all routes are searched and matched.
 // methods is a list of all the methods used in routes.
-type trieRegexpRouter struct {
-	root    *trieNode
-	methods []string
+// hosts, if not nil, links to the host nodes of the routes with a host; each
+// host node is the top of a tree like root.
+// notFound, if not nil, is the handler for requests that don't match a route.
+// badMethod, if not nil, is the handler for requests with a method that the
+// path doesn't have.
+// cache has the compiled regexp's of the PSE segments.
+// names has the paths of the named routes by name, see AddNamedRoute.
+// mu guards the tree and the lists, so routes can be added and removed while
+// requests are served.
+// frozen is true for a snapshot made by Freeze, which is never locked.
+type TrieRegexpRouter struct {
+	mu        sync.RWMutex
+	frozen    bool
+	root      *trieNode
+	methods   []string
+	hosts     *trieNode
+	notFound  HandlerFunc
+	badMethod HandlerFunc
+	cache     *regexpCache
+	names     map[string]string
+
+	// RedirectTrailingSlash, if true, makes FindHandler return a redirect error
+	// for paths with a trailing slash, when the path without it has a route.
+	// The error's Details is the canonical path, for use in a Location header.
+	// Otherwise, both paths match the same route.
+	// Defaults to false
+	RedirectTrailingSlash bool
+
+	// RedirectCleanPath, if true, makes FindHandler return a redirect error for
+	// paths with repeated slashes, when the path with single slashes has a route.
+	// Otherwise, the repeated slashes are collapsed and the path is matched.
+	// Defaults to false
+	RedirectCleanPath bool
+
+	// CleanDotSegments, if true, removes the "." segments of the paths matched,
+	// and each ".." segment with the segment before it, as path.Clean does;
+	// encoded dots, "%2E", too. So "/api/files/a/../b" matches as
+	// "/api/files/b", and a {path:varname} PSE never captures a ".." segment;
+	// otherwise it captures "a/../b" as is, and a handler that uses the value
+	// as a file path must clean it. With RedirectCleanPath, FindHandler returns
+	// a redirect error to the clean path instead.
+	// Defaults to false
+	CleanDotSegments bool
+
+	// CaseInsensitive, if true, matches the path segments without regard to
+	// case, the HTTP method is still case-sensitive. Captured values in
+	// Request.PathValues keep the original case of the request path.
+	// It must be set before any routes are added.
+	// Defaults to false
+	CaseInsensitive bool
+
+	// CombineRegexps, if true, compiles the regexp links of each path segment
+	// into one regexp, an alternation in the order they are tried. So a single
+	// match finds the link of a segment with many PSE's, instead of one match
+	// per PSE. It must be set before any routes are added.
+	// Defaults to false
+	CombineRegexps bool
+
+	// MaxSegmentLength, if not 0, is the length limit of the segments of the
+	// paths matched, escaped. FindHandler doesn't match a path with a longer
+	// segment, it returns ErrRouteNotFound before any matching. So the PSE's
+	// regexp's never run on long inputs; Go's regexp's run in linear time,
+	// without backtracking, but the time still grows with the input, and
+	// with the size of the regexp, like the date PSE's. The tail of a
+	// {path:varname} PSE, which joins the segments, is not limited.
+	// Defaults to 0
+	MaxSegmentLength int
+
+	// MaxPathLength and MaxSegments, if not 0, are the length limit of the
+	// paths matched, escaped and with the host if any; and the limit of the
+	// number of path segments, counted between slashes. FindHandler returns
+	// ErrRouteTooLong for a path over a limit, before any matching.
+	// Defaults to 0
+	MaxPathLength int
+	MaxSegments   int
 }
 
 // trieNode contains the routing information.
+// exp is the key of the segment's compiled regexp in the router's regexpCache,
+// empty for string segments.
+// fold is true if a string segment is matched case-insensitive.
 // handler, if not nil, points to the resource handler served by a specific route.
-// numExp is non-zero if the current path segment has regexp links.
+// numExp is the number of regexp links of the current path segment.
 // depth is the path depth of the current segment; 0 == HTTP verb.
 // links are the contiguous path segments.
+// tail, if not nil, is the link with a {path:varname} PSE that matches all the
+// remaining path segments.
+// defaults are the path values of an optional segment that is absent.
+// rx and groups, if not nil, are the compiled regexp of exp and its value
+// groups, set in frozen routers so the cache isn't used.
+// static, if not nil, maps the string links by segment; or by the segment in
+// lower case, for links matched case-insensitive. The links are kept in links
+// too, in order.
+// optional, if not empty, is the segment of an optional PSE route as it was
+// given, e.g. "{word:tab?}"; the node's pseg has the PSE without the marker.
+// route is the path of the node's route, as it was given to AddRoute.
+// combined, if not nil, has the regexp links compiled into one regexp, see
+// CombineRegexps.
+// statics, in method nodes, maps the path of each route below that has only
+// string segments to its node, e.g. "/api/users"; "" for the root path. See
+// findNode.
+// In frozen routers, a chain of string nodes without routes, each with one
+// link, is merged into one node; its pseg has the segments joined with "/",
+// and the rest are of the last segment. See compact.
 //
 // For example, given the following route and handler:
+//
 //		"GET /api/users/111" -> users.GetUser()
 //        - the path segment links are ["GET", "api", "users", "111"]
 //        - "GET" has depth=0 and "111" has depth=3
 //        - suppose "111" might be matched via regexp, then "users".numExp > 0
 //        - "111" segment will point to the handler users.GetUser()
 type trieNode struct {
-	pseg    string
-	handler HandlerFunc
-	numExp  int
-	depth   int
-	links   []*trieNode
+	pseg     string
+	exp      string
+	fold     bool
+	handler  HandlerFunc
+	numExp   int
+	depth    int
+	links    []*trieNode
+	tail     *trieNode
+	defaults url.Values
+	rx       *regexp.Regexp
+	groups   []valueGroup
+	static   map[string]*trieNode
+	optional string
+	route    string
+	combined *combinedExp
+	statics  map[string]*trieNode
 }
 
+// combinedExp is the regexp links of a node compiled into one regexp, with a
+// group that marks each alternative, and no other groups.
+// links has the index in the node's links of each alternative.
+// marks has the group index of the mark of each alternative.
+type combinedExp struct {
+	rx    *regexp.Regexp
+	links []int
+	marks []int
+}
+
+// findLink returns the link of the node for the segment 'pseg'; a string link
+// that matches it, or a regexp link with the same pattern. Or nil if none.
+// String links are looked up in the static map, so nodes with many links are
+// as fast as nodes with a few.
 func (n *trieNode) findLink(pseg string) *trieNode {
-	for i := range n.links {
-		if n.links[i].pseg == pseg {
-			return n.links[i]
+	if link := n.static[pseg]; link != nil {
+		return link
+	}
+	if lower := strings.ToLower(pseg); lower != pseg {
+		switch link := n.static[lower]; {
+		case link == nil:
+		case link.fold:
+			return link
+		default:
+			// a case-insensitive link may share the key of a case-sensitive one.
+			for _, other := range n.links[:len(n.links)-n.numExp] {
+				if other.fold && strings.EqualFold(other.pseg, pseg) {
+					return other
+				}
+			}
+		}
+	}
+	for _, link := range n.links[len(n.links)-n.numExp:] {
+		if link.pseg == pseg {
+			return link
 		}
 	}
 	return nil
 }
 
+// staticKey returns the key of the string link 'link' in the static map of
+// its node; for a merged link, the key of its first segment.
+func staticKey(link *trieNode) string {
+	pseg := link.pseg
+	if i := strings.IndexByte(pseg, '/'); i != -1 {
+		pseg = pseg[:i]
+	}
+	if link.fold {
+		return strings.ToLower(pseg)
+	}
+	return pseg
+}
+
+// pseRanks are the specificity scores of the PSE types, used to order the
+// regexp links of a node. Types that match fewer values rank higher, so a
+// {uint:id} is tried before a {word:name}. Custom regexp's rank above the
+// catch-all, which ranks last with 0.
+var pseRanks = map[string]int{
+	"enum":       90,
+	"uuid":       80,
+	"objectid":   80,
+	"date":       80,
+	"datestrict": 80,
+	"geo":        80,
+	"time":       80,
+	"duration":   80,
+	"ipv4":       80,
+	"ipv6":       80,
+	"ipcidr":     80,
+	"mac":        80,
+	"semver":     80,
+	"email":      80,
+	"phone":      80,
+	"colorhex":   80,
+	"bool":       70,
+	"port":       70,
+	"timestamp":  70,
+	"uint":       60,
+	"int":        50,
+	"float":      45,
+	"sci":        42,
+	"hex":        40,
+	"alpha":      40,
+	"alphanum":   30,
+	"word":       30,
+	"uword":      20,
+	"token":      20,
+	"hostname":   20,
+	"base64url":  10,
+	"base64":     10,
+	"re":         10,
+	"rei":        10,
+}
+
+// pseTypeExp matches the type of the first PSE in a path segment.
+var pseTypeExp = regexp.MustCompile(`\{(\w+)\:`)
+
+// pseRank returns the specificity score of the path segment 'pseg'. A segment
+// with text around its PSE, like "@{word:name}", ranks above the bare PSE.
+func pseRank(pseg string) int {
+	if pseg == "*" {
+		return 0
+	}
+	rank := 0
+	if m := pseTypeExp.FindStringSubmatch(pseg); m != nil {
+		rank = pseRanks[m[1]]
+		if _, ok := registeredType(m[1]); ok {
+			rank = pseTypeRank
+		}
+	}
+	if !strings.HasPrefix(pseg, "{") || !strings.HasSuffix(pseg, "}") {
+		rank += 5
+	}
+	return rank
+}
+
+// addLink inserts a link in the node. String links go before regexp links, which
+// are ordered by pseRank; links with the same rank are kept in the order they
+// were added. So the match precedence among overlapping PSE's doesn't depend on
+// the order of the routes.
+func (n *trieNode) addLink(link *trieNode) {
+	if link.exp == "" {
+		if n.static == nil {
+			n.static = make(map[string]*trieNode)
+		}
+		if key := staticKey(link); n.static[key] == nil {
+			n.static[key] = link
+		}
+	}
+	n.links = append(n.links, link)
+	rank := pseRank(link.pseg)
+	for i := range n.links[:len(n.links)-1] {
+		if n.links[i].exp != "" && (link.exp == "" || pseRank(n.links[i].pseg) < rank) {
+			copy(n.links[i+1:], n.links[i:len(n.links)-1])
+			n.links[i] = link
+			return
+		}
+	}
+}
+
+// removeLink removes a link from the node.
+func (n *trieNode) removeLink(link *trieNode) {
+	for i := range n.links {
+		if n.links[i] == link {
+			n.links = append(n.links[:i], n.links[i+1:]...)
+			break
+		}
+	}
+	if len(n.links) == 0 {
+		n.links = nil
+	}
+	if key := staticKey(link); link.exp == "" && n.static[key] == link {
+		// A link with the same key, if any, takes its place.
+		delete(n.static, key)
+		for _, other := range n.links {
+			if other.exp == "" && staticKey(other) == key {
+				n.static[key] = other
+				break
+			}
+		}
+		if len(n.static) == 0 {
+			n.static = nil
+		}
+	}
+	if link.exp != "" {
+		n.numExp--
+	}
+	if n.tail == link {
+		n.tail = nil
+	}
+}
+
 // segmentExp compiles the pattern string into a regexp so it can used in a
 // path segment match. This function will panic if the regexp compilation fails.
 func segmentExp(pattern string) *regexp.Regexp {
+	rx, err := compileSegment(pattern)
+	if err != nil {
+		panic(err.Error())
+	}
+	return rx
+}
+
+// compileSegment compiles the pattern string as segmentExp, but it returns an
+// error that names the pattern if the regexp compilation fails.
+func compileSegment(pattern string) (rx *regexp.Regexp, err error) {
+	defer recoverPSE(&err)
+	var expr string
+	switch {
 	// custom regexp pattern.
-	if strings.HasPrefix(pattern, "{re:") {
-		return regexp.MustCompile(pattern[4 : len(pattern)-1])
+	case strings.HasPrefix(pattern, "{re:"):
+		expr = pattern[4 : len(pattern)-1]
+	// custom regexp pattern, case-insensitive. The pattern is captured as a
+	// whole, so it doesn't need its own group.
+	case strings.HasPrefix(pattern, "{rei:"):
+		expr = `(?i)(` + pattern[5:len(pattern)-1] + `)`
+	// anchor the expression to the whole segment, so alternations are not cut
+	// short by a leftmost match.
+	default:
+		expr = `^(?:` + segmentPattern(pattern) + `)$`
+	}
+	rx, err = regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("relax: invalid path segment %q: %s", pattern, err.Error())
+	}
+	return rx, nil
+}
+
+// recoverPSE recovers the panic of segmentPattern for a PSE with an invalid
+// spec, like a bad range, into the error 'err'. Other panics are not recovered.
+func recoverPSE(err *error) {
+	if r := recover(); r != nil {
+		msg, ok := r.(string)
+		if !ok {
+			panic(r)
+		}
+		*err = errors.New(msg)
+	}
+}
+
+// pseType is a PSE type registered with RegisterType or RegisterMatcher;
+// pattern is the regexp of its values, and match the matcher, if any. The
+// matcher may be added while requests are matched, so it's an atomic.Value.
+type pseType struct {
+	pattern string
+	match   atomic.Value
+}
+
+// matcher returns the matcher of the type, or nil if none.
+func (t *pseType) matcher() func(string) bool {
+	fn, _ := t.match.Load().(func(string) bool)
+	return fn
+}
+
+// pseTypes are the registered PSE types by name, see RegisterType. It's guarded
+// by pseTypesMu, since types may be registered while routes are added.
+var (
+	pseTypesMu sync.RWMutex
+	pseTypes   = make(map[string]*pseType)
+)
+
+// pseTypeRank is the rank of the registered PSE types, as custom regexp's.
+const pseTypeRank = 10
+
+// registeredTypeExp matches a PSE that may be of a registered type, and
+// pseTypeNameExp the name of a type.
+var (
+	registeredTypeExp = regexp.MustCompile(`\{(\w+)\:(\w+)\}`)
+	pseTypeNameExp    = regexp.MustCompile(`^\w+$`)
+)
+
+/*
+RegisterType adds the PSE type 'name', so the PSE "{name:varname}" matches the
+values of the regexp 'pattern', stored in the varname. So domain-specific
+types, like order numbers and SKUs, don't need to repeat a custom regexp:
+
+	err := relax.RegisterType("sku", `[A-Z]{3}-\d{4}`)
+	// ...
+	router.AddRoute("GET", "/products/{sku:code}", handler)
+
+The pattern must match the whole value, it's anchored. Registered types rank
+as custom regexp's, see Router. The type must be registered before the routes
+that use it are added, and its pattern can't be changed once registered. A
+matcher may be added to it later, even after the routes; see RegisterMatcher.
+An error is returned if the name is not a word, or a type with the name exists,
+or the pattern doesn't compile. It is safe to call concurrently.
+*/
+func RegisterType(name, pattern string) error {
+	if _, err := regexp.Compile(`^(?:` + pattern + `)$`); err != nil {
+		return fmt.Errorf("relax: invalid pattern for PSE type %q: %s", name, err.Error())
+	}
+	pseTypesMu.Lock()
+	defer pseTypesMu.Unlock()
+	if err := checkTypeName(name); err != nil {
+		return err
+	}
+	if _, ok := pseTypes[name]; ok {
+		return fmt.Errorf("relax: PSE type %q already exists", name)
+	}
+	pseTypes[name] = &pseType{pattern: pattern}
+	return nil
+}
+
+// checkTypeName returns an error if 'name' can't be the name of a registered
+// PSE type: it's not a word, or it's a built-in type.
+func checkTypeName(name string) error {
+	if !pseTypeNameExp.MatchString(name) {
+		return fmt.Errorf("relax: invalid PSE type name %q", name)
+	}
+	if name == "path" || pseRanks[name] != 0 {
+		return fmt.Errorf("relax: PSE type %q already exists", name)
+	}
+	return nil
+}
+
+/*
+RegisterMatcher adds the matcher 'fn' to the PSE type 'name', for constraints
+that a regexp can't express; like the check digit of a credit card number. A
+value of the type matches only if fn returns true for it. Otherwise the link
+isn't matched, and the next one is tried as with any PSE that doesn't match.
+
+	relax.RegisterMatcher("card", luhn) // func luhn(s string) bool
+	router.AddRoute("POST", "/payments/{card:number}", handler)
+
+If the type was registered with RegisterType, fn checks the values matched by
+its pattern; in the routes added before the matcher too. Otherwise a new type
+is registered, which matches any segment for fn to check, and it must be
+registered before the routes that use it are added. Only one matcher may be
+added to a type. See RegisterType.
+*/
+func RegisterMatcher(name string, fn func(string) bool) error {
+	if fn == nil {
+		return fmt.Errorf("relax: nil matcher for PSE type %q", name)
+	}
+	pseTypesMu.Lock()
+	defer pseTypesMu.Unlock()
+	if err := checkTypeName(name); err != nil {
+		return err
+	}
+	t, ok := pseTypes[name]
+	if !ok {
+		t = &pseType{pattern: `.+`}
+		pseTypes[name] = t
+	}
+	if t.matcher() != nil {
+		return fmt.Errorf("relax: PSE type %q already has a matcher", name)
 	}
+	t.match.Store(fn)
+	return nil
+}
+
+// registeredType returns the registered PSE type 'name', and true if it exists.
+func registeredType(name string) (*pseType, bool) {
+	pseTypesMu.RLock()
+	defer pseTypesMu.RUnlock()
+	t, ok := pseTypes[name]
+	return t, ok
+}
 
+// segmentPattern returns the regexp pattern of the PSE's in the pattern string.
+// Custom regexp's are not expanded, see segmentExp.
+func segmentPattern(pattern string) string {
 	// turn "*" => "{wild}"
 	pattern = strings.Replace(pattern, "*", `{wild}`, -1)
 	// any: catch-all pattern
@@ -148,9 +1017,36 @@ func segmentExp(pattern string) *regexp.Regexp {
 			return fmt.Sprintf(`(?P<%s>.+)`, m[1:len(m)-1])
 		})
 	// word: matches an alphanumeric word, with underscores.
-	p = regexp.MustCompile(`\{(?:word\:)\w+\}`).
+	// An optional length limits the values matched:
+	// 	{word:varname(n)}       exactly n chars
+	// 	{word:varname(min,)}    min or more chars
+	// 	{word:varname(min,max)} between min and max chars
+	p = regexp.MustCompile(`\{(?:word\:)\w+(?:\([^)]*\))?\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			name, rep := lengthSpec(m[6 : len(m)-1])
+			return fmt.Sprintf(`(?P<%s>\w%s)`, name, rep)
+		})
+	// uword: matches a word in any script, with Unicode letters, digits and
+	// underscores. Unlike word, which is ASCII only.
+	p = regexp.MustCompile(`\{(?:uword\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>[\p{L}\p{N}_]+)`, m[7:len(m)-1])
+		})
+	// token: matches a word with dots and hyphens, like file names and versions.
+	// accepted value: report.v2-final
+	p = regexp.MustCompile(`\{(?:token\:)\w+\}`).
 		ReplaceAllStringFunc(p, func(m string) string {
-			return fmt.Sprintf(`(?P<%s>\w+)`, m[6:len(m)-1])
+			return fmt.Sprintf(`(?P<%s>[\w.\-]+)`, m[7:len(m)-1])
+		})
+	// alpha: matches a word with letters only.
+	p = regexp.MustCompile(`\{(?:alpha\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>[A-Za-z]+)`, m[7:len(m)-1])
+		})
+	// alphanum: matches a word with letters and digits, without underscores.
+	p = regexp.MustCompile(`\{(?:alphanum\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>[A-Za-z0-9]+)`, m[10:len(m)-1])
 		})
 	// date: matches a date as described in ISO 8601. see: https://en.wikipedia.org/wiki/ISO_8601
 	// accepted values:
@@ -174,6 +1070,17 @@ func segmentExp(pattern string) *regexp.Regexp {
 				`(?:T(?P<%[1]s_hour>([01][0-9])|(?:2[0123]))(\:?(?P<%[1]s_min>[0-5][0-9])(\:?(?P<%[1]s_sec>[0-5][0-9]([\,\.]\d{1,10})?))?)?(?:Z|([\-+](?:([01][0-9])|(?:2[0123]))(\:?(?:[0-5][0-9]))?))?)?`+
 				`))`, name)
 		})
+	// datestrict: matches a calendar date, YYYY-MM-DD, with the days of each
+	// month; Feb 29 only in leap years of the Gregorian calendar.
+	// accepted value: YYYY-MM-DD
+	p = regexp.MustCompile(`\{(?:datestrict\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>\d{4}\-(?:`+
+				`(?:0[13578]|1[02])\-(?:0[1-9]|[12]\d|3[01])|`+
+				`(?:0[469]|11)\-(?:0[1-9]|[12]\d|30)|`+
+				`02\-(?:0[1-9]|1\d|2[0-8]))|`+
+				`(?:\d\d(?:0[48]|[2468][048]|[13579][26])|(?:[02468][048]|[13579][26])00)\-02\-29)`, m[12:len(m)-1])
+		})
 	// geo: geo location in decimal. See http://tools.ietf.org/html/rfc5870
 	// accepted values:
 	// 	lat,lon           (point)
@@ -183,169 +1090,2304 @@ func segmentExp(pattern string) *regexp.Regexp {
 	// 	lat,lon;crs=name  (point with coordinate reference system (CRS) value)
 	p = regexp.MustCompile(`\{(?:geo\:)\w+\}`).ReplaceAllStringFunc(p, func(m string) string {
 		name := m[5 : len(m)-1]
-		return fmt.Sprintf(`(?P<%[1]s_lat>\-?\d+(\.\d+)?)[,;]`+
+		return fmt.Sprintf(`(?P<%[1]s>(?P<%[1]s_lat>\-?\d+(\.\d+)?)[,;]`+
 			`(?P<%[1]s_lon>\-?\d+(\.\d+)?)([,;]`+
 			`(?P<%[1]s_alt>\-?\d+(\.\d+)?))?(((?:;crs=)`+
 			`(?P<%[1]s_crs>[\w\-]+))?((?:;u=)`+
-			`(?P<%[1]s_u>\-?\d+(\.\d+)?))?)?`, name)
+			`(?P<%[1]s_u>\-?\d+(\.\d+)?))?)?)`, name)
 	})
-	// hex: matches a hexadecimal number.
-	// accepted value: 0xNN
-	p = regexp.MustCompile(`\{(?:hex\:)\w+\}`).
+	// time: matches a 24-hour clock time, with optional seconds.
+	// accepted values: HH:MM, HH:MM:SS
+	p = regexp.MustCompile(`\{(?:time\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>(?:[01]\d|2[0-3]):[0-5]\d(?::[0-5]\d)?)`, m[6:len(m)-1])
+		})
+	// timestamp: matches Unix epoch time in seconds, an unsigned integer.
+	// accepted value: 1700000000
+	p = regexp.MustCompile(`\{(?:timestamp\:)\w+\}`).
 		ReplaceAllStringFunc(p, func(m string) string {
-			return fmt.Sprintf(`(?P<%s>(?:0x)?[[:xdigit:]]+)`, m[5:len(m)-1])
+			return fmt.Sprintf(`(?P<%s>\d{1,11})`, m[11:len(m)-1])
+		})
+	// duration: matches a duration as described in ISO 8601, with at least one
+	// date or time component. See https://en.wikipedia.org/wiki/ISO_8601#Durations
+	// accepted values:
+	// 	PnYnMnWnD
+	// 	PTnHnMnS
+	// 	PnYnMnWnDTnHnMnS
+	// the smallest value may have a decimal fraction: PT1.5S
+	p = regexp.MustCompile(`\{(?:duration\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>P(?:`+
+				`(?:\d+Y(?:\d+M)?(?:\d+W)?(?:\d+D)?|\d+M(?:\d+W)?(?:\d+D)?|\d+W(?:\d+D)?|\d+D)`+
+				`(?:T(?:\d+H(?:\d+M)?(?:\d+(?:[,.]\d+)?S)?|\d+M(?:\d+(?:[,.]\d+)?S)?|\d+(?:[,.]\d+)?S))?|`+
+				`T(?:\d+H(?:\d+M)?(?:\d+(?:[,.]\d+)?S)?|\d+M(?:\d+(?:[,.]\d+)?S)?|\d+(?:[,.]\d+)?S)))`, m[10:len(m)-1])
+		})
+	// hex: matches a hexadecimal number, with an optional "0x" prefix and at
+	// least one digit. An optional length limits the number of digits, the
+	// prefix is not counted; see word.
+	// accepted values: NN, 0xNN
+	p = regexp.MustCompile(`\{(?:hex\:)\w+(?:\([^)]*\))?\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			name, rep := lengthSpec(m[5 : len(m)-1])
+			return fmt.Sprintf(`(?P<%s>(?:0x)?[[:xdigit:]]%s)`, name, rep)
 		})
 	// uuid: matches an UUID using hex octets, with optional dashes.
+	// An optional version, {uuid:varname:4}, limits the UUID's matched to that
+	// version, 1 to 8, with the RFC 4122 variant. See https://tools.ietf.org/html/rfc4122#section-4.1
 	// accepted value: NNNNNNNN-NNNN-NNNN-NNNN-NNNNNNNNNNNN
-	p = regexp.MustCompile(`\{(?:uuid\:)\w+\}`).
+	p = regexp.MustCompile(`\{(?:uuid\:)\w+(?:\:\d+)?\}`).
 		ReplaceAllStringFunc(p, func(m string) string {
+			name, version, variant := m[6:len(m)-1], `[[:xdigit:]]`, `[[:xdigit:]]`
+			if i := strings.Index(name, ":"); i != -1 {
+				if v := name[i+1:]; len(v) != 1 || v < "1" || v > "8" {
+					panic("relax: PSE uuid version must be 1 to 8: " + m)
+				}
+				name, version, variant = name[:i], name[i+1:], `[89abAB]`
+			}
 			return fmt.Sprintf(`(?P<%s>[[:xdigit:]]{8}\-?`+
 				`[[:xdigit:]]{4}\-?`+
-				`[[:xdigit:]]{4}\-?`+
-				`[[:xdigit:]]{4}\-?`+
-				`[[:xdigit:]]{12})`, m[6:len(m)-1])
+				`%s[[:xdigit:]]{3}\-?`+
+				`%s[[:xdigit:]]{3}\-?`+
+				`[[:xdigit:]]{12})`, name, version, variant)
+		})
+	// colorhex: matches a hex color, with optional "#" prefix. Clients must send
+	// the "#" percent-encoded as "%23".
+	// accepted values: [#]RGB, [#]RRGGBB, [#]RRGGBBAA
+	p = regexp.MustCompile(`\{(?:colorhex\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>#?(?:[[:xdigit:]]{3}|[[:xdigit:]]{6}|[[:xdigit:]]{8}))`, m[10:len(m)-1])
 		})
-	// float: matches a floating-point number
+	// float: matches a floating-point number in decimal notation, the fraction
+	// is optional so integers match too.
+	// accepted values: 100, 100.5, -3, +0.25
 	p = regexp.MustCompile(`\{(?:float\:)\w+\}`).
 		ReplaceAllStringFunc(p, func(m string) string {
-			return fmt.Sprintf(`(?P<%s>[\-+]?\d+\.\d+)`, m[7:len(m)-1])
+			return fmt.Sprintf(`(?P<%s>[\-+]?\d+(?:\.\d+)?)`, m[7:len(m)-1])
+		})
+	// sci: matches a number in scientific notation, a decimal mantissa with an
+	// optional exponent.
+	// accepted values: 1e10, -2.5E-3, 3.14
+	p = regexp.MustCompile(`\{(?:sci\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>[\-+]?\d+(?:\.\d+)?(?:[eE][\-+]?\d+)?)`, m[5:len(m)-1])
 		})
-	// uint: matches an unsigned integer number (64bit)
-	p = regexp.MustCompile(`\{(?:uint\:)\w+\}`).
+	// uint: matches an unsigned integer number of any length, so large IDs are
+	// routed too. The value may overflow uint64, the handler must check that
+	// when it parses the value.
+	// An optional inclusive range limits the values matched: {uint:varname(min,max)}
+	p = regexp.MustCompile(`\{(?:uint\:)\w+(?:\([^)]*\))?\}`).
 		ReplaceAllStringFunc(p, func(m string) string {
-			return fmt.Sprintf(`(?P<%s>\d{1,18})`, m[6:len(m)-1])
+			name, min, max, ok := rangeSpec(m[6 : len(m)-1])
+			if !ok {
+				return fmt.Sprintf(`(?P<%s>\d+)`, name)
+			}
+			if min < 0 {
+				panic("relax: PSE uint range must not be negative: " + m)
+			}
+			return fmt.Sprintf(`(?P<%s>%s)`, name, rangeExp(min, max))
 		})
 	// int: matches a signed integer number (64bit)
-	p = regexp.MustCompile(`\{(?:int\:)\w+\}`).
+	// An optional inclusive range limits the values matched: {int:varname(min,max)}
+	p = regexp.MustCompile(`\{(?:int\:)\w+(?:\([^)]*\))?\}`).
 		ReplaceAllStringFunc(p, func(m string) string {
-			return fmt.Sprintf(`(?P<%s>[-+]?\d{1,18})`, m[5:len(m)-1])
+			name, min, max, ok := rangeSpec(m[5 : len(m)-1])
+			if !ok {
+				return fmt.Sprintf(`(?P<%s>[-+]?\d{1,18})`, name)
+			}
+			return fmt.Sprintf(`(?P<%s>%s)`, name, rangeExp(min, max))
 		})
-	return regexp.MustCompile(p)
+	// port: matches a port number between 0 and 65535, without leading zeros.
+	p = regexp.MustCompile(`\{(?:port\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>6553[0-5]|655[0-2]\d|65[0-4]\d{2}|6[0-4]\d{3}|[1-5]\d{4}|[1-9]\d{0,3}|0)`, m[6:len(m)-1])
+		})
+	// bool: matches a boolean literal, case-insensitive. The value is not normalized.
+	// accepted values: true, false, yes, no, on, off, 1, 0
+	p = regexp.MustCompile(`\{(?:bool\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>(?i:true|false|yes|no|on|off|1|0))`, m[6:len(m)-1])
+		})
+	// email: matches an email address, a subset of RFC 5322 addr-spec without
+	// quoted strings or comments. See https://tools.ietf.org/html/rfc5322#section-3.4.1
+	// accepted value: local.part+tag@sub.domain.tld
+	p = regexp.MustCompile(`\{(?:email\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%[1]s>`+
+				`[\w!#$%%&'*+=?^~\-]+(?:\.[\w!#$%%&'*+=?^~\-]+)*@`+
+				`%[2]s(?:\.%[2]s)+)`, m[7:len(m)-1], labelExp)
+		})
+	// hostname: matches a DNS host name, one or more labels separated by dots.
+	// Labels are letters, digits and hyphens, up to 63 chars, and don't begin or
+	// end with a hyphen. See https://tools.ietf.org/html/rfc1123#section-2.1
+	// accepted value: api.example.com
+	p = regexp.MustCompile(`\{(?:hostname\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%[1]s>%[2]s(?:\.%[2]s)*)`, m[10:len(m)-1], labelExp)
+		})
+	// ipv4: matches an IPv4 address in dotted-quad notation, octets 0-255.
+	// accepted value: NNN.NNN.NNN.NNN
+	p = regexp.MustCompile(`\{(?:ipv4\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>%s)`, m[6:len(m)-1], ipv4Exp)
+		})
+	// ipv6: matches an IPv6 address as described in RFC 4291 section 2.2, with
+	// optional zone ID for link-local addresses. See https://tools.ietf.org/html/rfc4291
+	// accepted values:
+	// 	2001:db8:0:0:0:0:2:1
+	// 	2001:db8::1
+	// 	::1
+	// 	::ffff:192.0.2.1
+	// 	fe80::1%eth0
+	p = regexp.MustCompile(`\{(?:ipv6\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>%s)`, m[6:len(m)-1], ipv6Exp)
+		})
+	// ipcidr: matches an IPv4 or IPv6 address block in CIDR notation, as
+	// described in RFC 4632 and RFC 4291 section 2.3. Because the prefix separator
+	// is also the path separator, clients must percent-encode it as "%2F".
+	// accepted values:
+	// 	10.0.0.0/8
+	// 	2001:db8::/32
+	p = regexp.MustCompile(`\{(?:ipcidr\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>`+
+				`%s(?:/|%%2[fF])(?:3[0-2]|[12]?\d)|`+
+				`%s(?:/|%%2[fF])(?:12[0-8]|1[01]\d|[1-9]?\d))`, m[8:len(m)-1], ipv4Exp, ipv6Exp)
+		})
+	// phone: matches an international phone number in E.164 format. The "+" is
+	// literal in a path, it is not decoded as a space like in query strings.
+	// See https://www.itu.int/rec/T-REC-E.164
+	// accepted value: +NNNNNNNNNNN, up to 15 digits
+	p = regexp.MustCompile(`\{(?:phone\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>\+[1-9]\d{1,14})`, m[7:len(m)-1])
+		})
+	// mac: matches a 48-bit MAC address, with a consistent separator.
+	// accepted values:
+	// 	01:23:45:67:89:ab
+	// 	01-23-45-67-89-AB
+	// 	0123.4567.89ab
+	p = regexp.MustCompile(`\{(?:mac\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>`+
+				`(?:[[:xdigit:]]{2}:){5}[[:xdigit:]]{2}|`+
+				`(?:[[:xdigit:]]{2}\-){5}[[:xdigit:]]{2}|`+
+				`(?:[[:xdigit:]]{4}\.){2}[[:xdigit:]]{4})`, m[5:len(m)-1])
+		})
+	// base64: matches base64 encoded data, with optional padding. See https://tools.ietf.org/html/rfc4648#section-4
+	// accepted value: SGVsbG8sIHdvcmxk[=[=]]
+	p = regexp.MustCompile(`\{(?:base64\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>%s)`, m[8:len(m)-1], base64Exp(`[[:alnum:]+/]`))
+		})
+	// base64url: matches base64 encoded data with the URL and filename safe
+	// alphabet, with optional padding. See https://tools.ietf.org/html/rfc4648#section-5
+	// accepted value: eyJpZCI6MTIzfQ[=[=]]
+	p = regexp.MustCompile(`\{(?:base64url\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>%s)`, m[11:len(m)-1], base64Exp(`[[:alnum:]_\-]`))
+		})
+	// semver: matches a semantic version 2.0.0. See https://semver.org
+	// accepted values:
+	// 	1.2.3
+	// 	1.0.0-alpha.1
+	// 	1.0.0+build.5
+	// 	1.0.0-rc.1+build.5
+	p = regexp.MustCompile(`\{(?:semver\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>`+
+				`(?:0|[1-9]\d*)\.(?:0|[1-9]\d*)\.(?:0|[1-9]\d*)`+
+				`(?:\-(?:0|[1-9]\d*|\d*[[:alpha:]\-][[:alnum:]\-]*)(?:\.(?:0|[1-9]\d*|\d*[[:alpha:]\-][[:alnum:]\-]*))*)?`+
+				`(?:\+[[:alnum:]\-]+(?:\.[[:alnum:]\-]+)*)?)`, m[8:len(m)-1])
+		})
+	// objectid: matches a MongoDB ObjectID, a 12-byte value in hex.
+	// accepted value: NNNNNNNNNNNNNNNNNNNNNNNN
+	p = regexp.MustCompile(`\{(?:objectid\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>[[:xdigit:]]{24})`, m[10:len(m)-1])
+		})
+	// enum: matches one of a list of literal values, separated by "|".
+	// accepted value: any of the values listed; e.g., {enum:period:daily|weekly|monthly}
+	p = regexp.MustCompile(`\{(?:enum\:)\w+\:[^}]*\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			name := m[6 : strings.Index(m[6:], ":")+6]
+			alts := strings.Split(m[len(name)+7:len(m)-1], "|")
+			for i := range alts {
+				if alts[i] == "" {
+					panic("relax: PSE enum needs a list of non-empty values: " + m)
+				}
+				alts[i] = regexp.QuoteMeta(alts[i])
+			}
+			return fmt.Sprintf(`(?P<%s>%s)`, name, strings.Join(alts, "|"))
+		})
+	// path: matches the rest of a path, across segments. The router joins the
+	// remaining segments before matching.
+	// accepted value: any/path/value.ext
+	p = regexp.MustCompile(`\{(?:path\:)\w+\}`).
+		ReplaceAllStringFunc(p, func(m string) string {
+			return fmt.Sprintf(`(?P<%s>.+)`, m[6:len(m)-1])
+		})
+	// registered types, see RegisterType. They are expanded last, so their
+	// patterns are not taken for PSE's.
+	p = registeredTypeExp.ReplaceAllStringFunc(p, func(m string) string {
+		sub := registeredTypeExp.FindStringSubmatch(m)
+		if t, ok := registeredType(sub[1]); ok {
+			return fmt.Sprintf(`(?P<%s>%s)`, sub[2], t.pattern)
+		}
+		return m
+	})
+	return p
 }
 
 // AddRoute breaks a path into segments and inserts them in the tree. If a
 // segment contains matching {}'s then it is tried as a regexp segment, otherwise it is
 // treated as a regular string segment.
-func (router *trieRegexpRouter) AddRoute(method, path string, handler HandlerFunc) {
-	node := router.root
-	pseg := strings.Split(method+strings.TrimRight(path, "/"), "/")
+// A {path:varname} PSE matches all the remaining path segments, so it may only
+// be used in the last segment. An optional PSE, {type:varname?} or
+// {type:varname=default}, adds the route with and without the segment; it may
+// only be used in the last segment too, and the path without the segment must
+// not be a route already.
+// A path that begins with "//" is a host route, see hostNode.
+// The method must not be empty, nor have "/" or spaces, and the path must begin
+// with "/". This function will panic otherwise, or if a PSE is not valid; see
+// AddRouteErr to get an error instead.
+// The method is matched case-insensitive, it is stored in uppercase.
+// Adding a route that exists replaces its handler, as ReplaceRoute.
+// It is safe to call concurrently with FindHandler.
+func (router *TrieRegexpRouter) AddRoute(method, path string, handler HandlerFunc) {
+	router.lock()
+	defer router.mu.Unlock()
+	router.mustAddRoute(method, path, handler)
+}
+
+// AddRouteErr adds a route as AddRoute, but it returns an error if the route
+// is not valid, instead of panicking; e.g., a PSE with a bad range, or a custom
+// regexp that doesn't compile. So routes can be built from configuration or
+// user input. The router is not changed if there's an error.
+//
+//	if err := router.AddRouteErr("GET", conf.Path, handler); err != nil {
+//		log.Printf("skipping route: %s", err.Error())
+//	}
+func (router *TrieRegexpRouter) AddRouteErr(method, path string, handler HandlerFunc) error {
+	if router.frozen {
+		return errors.New("relax: router is frozen")
+	}
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.addRoute(method, path, handler)
+}
+
+// mustAddRoute adds a route as addRoute, and panics if there's an error.
+func (router *TrieRegexpRouter) mustAddRoute(method, path string, handler HandlerFunc) {
+	if err := router.addRoute(method, path, handler); err != nil {
+		panic(err.Error())
+	}
+}
+
+// checkRoute returns an error if the route of 'method' and 'path' is not
+// valid: the method is empty or has "/" or spaces, the path doesn't begin with
+// "/", an optional or {path:varname} PSE is not in the last segment, or a PSE
+// doesn't compile. The PSE's compiled are stored in the router's cache.
+func (router *TrieRegexpRouter) checkRoute(method, path string) error {
+	if method == "" || strings.ContainsAny(method, "/ \t") {
+		return errors.New("relax: invalid route method: " + strconv.Quote(method))
+	}
+	if !strings.HasPrefix(path, "/") {
+		return errors.New("relax: route path must begin with \"/\": " + strconv.Quote(path))
+	}
+	if host, rest := splitHost(path); host != "" {
+		if _, err := compileHost(host); err != nil {
+			return err
+		}
+		path = rest
+	}
+	pseg := pathSegments(method, path)
+	for i := range pseg {
+		pse, _, _, optional := optionalSegment(pseg[i])
+		if optional && i != len(pseg)-1 {
+			return errors.New("relax: optional PSE must be the last path segment: " + path)
+		}
+		if strings.Contains(pse, "{path:") && i != len(pseg)-1 {
+			return errors.New("relax: PSE {path:varname} must be the last path segment: " + path)
+		}
+		if _, err := router.segmentKey(pse); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addRoute adds a route as AddRouteErr, with the router already locked.
+func (router *TrieRegexpRouter) addRoute(method, path string, handler HandlerFunc) error {
+	if err := router.checkRoute(method, path); err != nil {
+		return err
+	}
+	method = strings.ToUpper(method)
+	trimmed := strings.TrimRight(path, "/")
+	last := strings.LastIndex(trimmed, "/")
+	if _, _, _, ok := optionalSegment(trimmed[last+1:]); ok {
+		// the route without the segment must not take another's handler.
+		parent, _, _ := router.walkRoute(method, trimmed[:last], false)
+		if parent != nil {
+			node := parent[len(parent)-1]
+			if node.handler != nil && strings.TrimRight(node.route, "/") != trimmed {
+				return fmt.Errorf("relax: optional route %s %s conflicts with route %s", method, path, node.route)
+			}
+		}
+	}
+	nodes, optional, defaults := router.walkRoute(method, path, true)
+	nodes[len(nodes)-1].handler = handler
+	nodes[len(nodes)-1].route = path
+	nodes[len(nodes)-1].defaults = nil
+	setStatic(nodes)
+	if optional {
+		nodes[len(nodes)-1].optional = trimmed[last+1:]
+		nodes[len(nodes)-2].handler = handler
+		nodes[len(nodes)-2].defaults = defaults
+		nodes[len(nodes)-2].route = path
+		setStatic(nodes[:len(nodes)-1])
+	}
+
+	// update methods list
+	for i := range router.methods {
+		if router.methods[i] == method {
+			return nil
+		}
+	}
+	router.methods = append(router.methods, method)
+	return nil
+}
+
+// AddRoutes adds the route of 'path' to the same handler for each method in
+// 'methods'; e.g., []string{"PUT", "PATCH"}. Repeated methods are added once.
+// See AddRoute.
+func (router *TrieRegexpRouter) AddRoutes(methods []string, path string, handler HandlerFunc) {
+	router.lock()
+	defer router.mu.Unlock()
+	added := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		method = strings.ToUpper(method)
+		if added[method] {
+			continue
+		}
+		added[method] = true
+		router.mustAddRoute(method, path, handler)
+	}
+}
+
+// AddNamedRoute adds a route as AddRoute, and associates 'name' with its path,
+// so URL can build paths to it. A name may be given to the routes of several
+// methods with the same path. This function will panic if the name is used for
+// another path.
+//
+//	router.AddNamedRoute("user", "GET", "/api/users/{uint:id}", handler)
+//	path, err := router.URL("user", map[string]string{"id": "5"})
+//	// path == "/api/users/5"
+func (router *TrieRegexpRouter) AddNamedRoute(name, method, path string, handler HandlerFunc) {
+	router.lock()
+	defer router.mu.Unlock()
+	if p, ok := router.names[name]; ok && p != path {
+		panic("relax: route name already used: " + strconv.Quote(name))
+	}
+	router.mustAddRoute(method, path, handler)
+	if router.names == nil {
+		router.names = make(map[string]string)
+	}
+	router.names[name] = path
+}
+
+// ReplaceRoute replaces the handler of the route of 'method' and 'path', as
+// they were given to AddRoute. The tree is not changed, so it is safe to call
+// it repeatedly. This function will panic if the route doesn't exist.
+func (router *TrieRegexpRouter) ReplaceRoute(method, path string, handler HandlerFunc) {
+	router.lock()
+	defer router.mu.Unlock()
+	method = strings.ToUpper(method)
+	nodes, optional, _ := router.walkRoute(method, path, false)
+	if nodes == nil || nodes[len(nodes)-1].handler == nil {
+		panic("relax: no route to replace: " + method + " " + path)
+	}
+	nodes[len(nodes)-1].handler = handler
+	if optional && nodes[len(nodes)-2].route == nodes[len(nodes)-1].route {
+		nodes[len(nodes)-2].handler = handler
+	}
+}
+
+// walkRoute walks the tree along the segments of the route of 'method' and
+// 'path'. If 'add' is true the missing segments are inserted, otherwise it
+// returns nil if a segment is missing.
+// It returns the nodes walked, beginning with the top of the tree; the last
+// one is the route node. optional is true if the route ends in an optional
+// PSE, and defaults are its default value if any.
+// A route is checked by checkRoute before it's added, so its PSE's are
+// compiled before the tree is changed.
+func (router *TrieRegexpRouter) walkRoute(method, path string, add bool) (nodes []*trieNode, optional bool, defaults url.Values) {
+	host, rest := splitHost(path)
+	if host != "" {
+		path = rest
+	}
+	pseg := pathSegments(method, path)
+	exps := make([]string, len(pseg))
 	for i := range pseg {
-		if (strings.Contains(pseg[i], "{") && strings.Contains(pseg[i], "}")) || strings.Contains(pseg[i], "*") {
-			if _, ok := pathRegexpCache[pseg[i]]; !ok {
-				pathRegexpCache[pseg[i]] = segmentExp(pseg[i])
+		if pse, name, value, ok := optionalSegment(pseg[i]); ok {
+			pseg[i] = pse
+			optional = true
+			if name != "" {
+				defaults = url.Values{name: {value}}
+			}
+		}
+		if add {
+			exp, err := router.segmentKey(pseg[i])
+			if err != nil {
+				panic(err.Error())
 			}
-			node.numExp++
+			exps[i] = exp
+		}
+	}
+
+	node := router.root
+	if host != "" {
+		switch {
+		case add:
+			node = router.hostNode(host)
+		case router.hosts != nil:
+			node = router.hosts.findLink(host)
+		default:
+			node = nil
+		}
+		if node == nil {
+			return nil, false, nil
 		}
+	}
+	nodes = append(make([]*trieNode, 0, len(pseg)+1), node)
+	for i := range pseg {
 		link := node.findLink(pseg[i])
 		if link == nil {
-			link = &trieNode{
-				pseg:  pseg[i],
-				depth: node.depth + 1,
+			if !add {
+				return nil, false, nil
 			}
-			node.links = append(node.links, link)
+			link = router.newLink(node, pseg[i], exps[i])
+		}
+		if strings.Contains(pseg[i], "{path:") {
+			node.tail = link
+		}
+		node = link.expand()
+		nodes = append(nodes, node)
+	}
+	return nodes, optional, defaults
+}
+
+// setStatic updates the statics of the method node with the route node at the
+// end of 'nodes', as walkRoute returns them: the node is added if it has a
+// handler, and removed otherwise. Routes with PSE's are not in the statics.
+func setStatic(nodes []*trieNode) {
+	var path string
+	for _, node := range nodes[2:] {
+		if node.exp != "" {
+			return
 		}
-		node = link
+		path += "/" + node.pseg
+	}
+	method, node := nodes[1], nodes[len(nodes)-1]
+	if node.handler == nil {
+		delete(method.statics, path)
+		return
+	}
+	if method.statics == nil {
+		method.statics = make(map[string]*trieNode)
 	}
+	method.statics[path] = node
+}
 
-	node.handler = handler
+// segmentKey returns the key of the path segment 'pseg' in the router's cache,
+// or "" if it's a string segment. A PSE segment is compiled into the cache, if
+// it's not there already. The key is the string stored in the cache, shared by
+// all its routes. It returns an error if the compilation fails.
+func (router *TrieRegexpRouter) segmentKey(pseg string) (string, error) {
+	if !(strings.Contains(pseg, "{") && strings.Contains(pseg, "}")) && !strings.Contains(pseg, "*") {
+		return "", nil
+	}
+	exp := pseg
+	if router.CaseInsensitive {
+		exp = "(?i)" + exp
+	}
+	if rx, _ := router.cache.load(exp); rx == nil {
+		rx, err := compileSegment(pseg)
+		if err != nil {
+			return "", err
+		}
+		if router.CaseInsensitive {
+			rx = regexp.MustCompile("(?i)" + rx.String())
+		}
+		router.cache.store(exp, rx)
+	}
+	return router.cache.intern(exp), nil
+}
 
-	// update methods list
-	if !strings.Contains(strings.Join(router.methods, ","), method) {
-		router.methods = append(router.methods, method)
+// newLink inserts a link for the path segment 'pseg' in node. exp is the key
+// of a PSE segment in the router's cache, see segmentKey.
+func (router *TrieRegexpRouter) newLink(node *trieNode, pseg, exp string) *trieNode {
+	if exp != "" {
+		node.numExp++
 	}
+	link := &trieNode{
+		pseg:  pseg,
+		exp:   exp,
+		fold:  router.CaseInsensitive && exp == "",
+		depth: node.depth + 1,
+	}
+	node.addLink(link)
+	router.combineLinks(node)
+	return link
 }
 
-// matchSegment tries to match a path segment 'pseg' to the node's regexp links.
-// This function will return any path values matched so they can be used in
-// Request.PathValues.
-func (node *trieNode) matchSegment(pseg string, depth int, values *url.Values) *trieNode {
-	if node.numExp == 0 {
-		return node.findLink(pseg)
+// combineLinks compiles the regexp links of the node into its combined regexp,
+// if the router has CombineRegexps set and the node has two or more regexp
+// links; otherwise the node has no combined regexp. The tail link is not
+// combined.
+func (router *TrieRegexpRouter) combineLinks(node *trieNode) {
+	node.combined = nil
+	if !router.CombineRegexps || node.numExp < 2 {
+		return
 	}
-	for pexp := range node.links {
-		rx := pathRegexpCache[node.links[pexp].pseg]
-		if rx == nil {
+	c := new(combinedExp)
+	var alts []string
+	mark := 1
+	for i, link := range node.links {
+		if link.exp == "" || strings.Contains(link.pseg, "{path:") {
 			continue
 		}
-		// this prevents the matching to be side-tracked by smaller paths.
-		if depth > node.links[pexp].depth && node.links[pexp].links == nil {
+		rx := router.nodeRegexp(link)
+		alts = append(alts, "("+uncaptured(rx)+")")
+		c.links = append(c.links, i)
+		c.marks = append(c.marks, mark)
+		mark++
+	}
+	if len(alts) < 2 {
+		return
+	}
+	rx, err := regexp.Compile(`^(?:` + strings.Join(alts, "|") + `)$`)
+	if err != nil {
+		return
+	}
+	c.rx = rx
+	node.combined = c
+}
+
+// DeleteRoute removes the route of 'method' and 'path', as they were given to
+// AddRoute, and prunes the path segments that are left without routes. An
+// optional PSE route is removed with and without the segment. If no routes are
+// left for 'method' it is removed from the methods list.
+// It does nothing if the route doesn't exist.
+func (router *TrieRegexpRouter) DeleteRoute(method, path string) {
+	router.lock()
+	defer router.mu.Unlock()
+	method = strings.ToUpper(method)
+	nodes, optional, _ := router.walkRoute(method, path, false)
+	if nodes == nil {
+		return
+	}
+
+	nodes[len(nodes)-1].handler = nil
+	nodes[len(nodes)-1].route = ""
+	setStatic(nodes)
+	if optional {
+		nodes[len(nodes)-1].optional = ""
+		// the path without the segment may be a route added after this one.
+		if parent := nodes[len(nodes)-2]; strings.TrimRight(parent.route, "/") == strings.TrimRight(path, "/") {
+			parent.handler = nil
+			parent.route = ""
+			parent.defaults = nil
+			setStatic(nodes[:len(nodes)-1])
+		}
+	}
+	for i := len(nodes) - 1; i > 0 && nodes[i].handler == nil && nodes[i].links == nil; i-- {
+		nodes[i-1].removeLink(nodes[i])
+		router.combineLinks(nodes[i-1])
+	}
+	// the names of the path are removed with its last route.
+	if router.names != nil && !router.hasRoutes(path) {
+		for name, p := range router.names {
+			if p == path {
+				delete(router.names, name)
+			}
+		}
+	}
+	if host := nodes[0]; host != router.root && host.links == nil {
+		router.hosts.removeLink(host)
+		if router.hosts.links == nil {
+			router.hosts = nil
+		}
+	}
+
+	// update methods list
+	if router.root.findLink(method) != nil {
+		return
+	}
+	if router.hosts != nil {
+		for i := range router.hosts.links {
+			if router.hosts.links[i].findLink(method) != nil {
+				return
+			}
+		}
+	}
+	for i := range router.methods {
+		if router.methods[i] == method {
+			router.methods = append(router.methods[:i], router.methods[i+1:]...)
+			break
+		}
+	}
+}
+
+// HasRoute returns true if the route of 'method' and 'path' exists, as they
+// were given to AddRoute. The PSE's are compared as patterns, not matched; so
+// "/api/users/{uint:id}" has a route but "/api/users/5" doesn't. To match a
+// request path use FindHandler.
+func (router *TrieRegexpRouter) HasRoute(method, path string) bool {
+	router.rlock()
+	defer router.runlock()
+	return router.hasRoute(strings.ToUpper(method), path)
+}
+
+// hasRoute is HasRoute, with the router already locked.
+func (router *TrieRegexpRouter) hasRoute(method, path string) bool {
+	nodes, _, _ := router.walkRoute(method, path, false)
+	return nodes != nil && nodes[len(nodes)-1].handler != nil
+}
+
+// hasRoutes returns true if 'path', as given to AddRoute, has a route with any
+// method.
+func (router *TrieRegexpRouter) hasRoutes(path string) bool {
+	for _, method := range router.methods {
+		if router.hasRoute(method, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// URL returns the path of the route named 'name', see AddNamedRoute, with its
+// PSE's replaced by the values in 'params', by varname. A PSE without varname,
+// like a custom regexp without named groups, may be given by position as "_1",
+// "_2", ..., counting the PSE's of the route. A "*" has the varname "wild".
+// The values are checked with the PSE's, and escaped. An optional PSE without
+// a value is left out. Host routes return the host as "//host/path".
+// It returns an error if the name has no route, or a value is missing or
+// doesn't match its PSE.
+func (router *TrieRegexpRouter) URL(name string, params map[string]string) (string, error) {
+	router.rlock()
+	defer router.runlock()
+	path, ok := router.names[name]
+	if !ok {
+		return "", fmt.Errorf("relax: no route named %q", name)
+	}
+	n := 0
+	host, rest := splitHost(path)
+	if host != "" {
+		h, err := replacePSE(host, &n, params)
+		if err != nil {
+			return "", err
+		}
+		if rx, _ := router.cache.load("//" + host); rx != nil && !rx.MatchString(h) {
+			return "", fmt.Errorf("relax: invalid host %q for %q", h, host)
+		}
+		host, path = "//"+h, rest
+	}
+	pseg := strings.Split(strings.Trim(path, "/"), "/")
+	for i := range pseg {
+		pse, _, _, optional := optionalSegment(pseg[i])
+		seg, err := replacePSE(pse, &n, params)
+		if err != nil {
+			if optional {
+				pseg = pseg[:i]
+				break
+			}
+			return "", err
+		}
+		exp, err := router.segmentKey(pse)
+		if err != nil {
+			return "", err
+		}
+		if exp != "" {
+			if rx, _ := router.cache.load(exp); rx.FindString(seg) != seg {
+				return "", fmt.Errorf("relax: invalid value %q for path segment %q", seg, pse)
+			}
+		}
+		parts := strings.Split(seg, "/")
+		for j := range parts {
+			parts[j] = url.PathEscape(parts[j])
+		}
+		pseg[i] = strings.Join(parts, "/")
+	}
+	return host + "/" + strings.Join(pseg, "/"), nil
+}
+
+// pseNameExp matches the type and varname of a PSE.
+var pseNameExp = regexp.MustCompile(`^\{(?:(\w+)\:)?(\w+)`)
+
+// pseGroupExp matches the first named group of a custom regexp PSE.
+var pseGroupExp = regexp.MustCompile(`\(\?P<(\w+)>`)
+
+// replacePSE returns the path segment 'pseg' with its PSE's replaced by their
+// values in 'params', see URL. n is the number of PSE's before the segment, and
+// it's increased by the PSE's found. It returns an error if a value is missing.
+func replacePSE(pseg string, n *int, params map[string]string) (string, error) {
+	text, pses := splitPSE(pseg)
+	seg := text[0]
+	for i, pse := range pses {
+		*n++
+		name := pseName(pse)
+		value, ok := params[name]
+		if !ok || name == "" {
+			value, ok = params["_"+strconv.Itoa(*n)]
+		}
+		if !ok {
+			return "", fmt.Errorf("relax: missing value for %q in path segment %q", pse, pseg)
+		}
+		seg += value + text[i+1]
+	}
+	return seg, nil
+}
+
+// splitPSE splits the path segment 'pseg' in its PSE's and the text around
+// them; text has one more item than pses, text[i] is before pses[i]. A "*" is
+// a PSE too.
+func splitPSE(pseg string) (text, pses []string) {
+	at := 0
+	for i := 0; i < len(pseg); i++ {
+		if pseg[i] != '{' && pseg[i] != '*' {
 			continue
 		}
-		m := rx.FindStringSubmatch(pseg)
-		if len(m) > 1 && m[0] == pseg {
-			if values != nil {
-				if *values == nil {
-					*values = make(url.Values)
-				}
-				sub := rx.SubexpNames()
-				for i, n := 1, len(*values)/2; i < len(m); i++ {
-					_n := fmt.Sprintf("_%d", n+i)
-					(*values).Set(_n, m[i])
-					if sub[i] != "" {
-						(*values).Add(sub[i], m[i])
+		text = append(text, pseg[at:i])
+		pse := "*"
+		if pseg[i] == '{' {
+			// PSE's may have braces inside, as in custom regexp's.
+			depth, j := 0, i
+			for ; j < len(pseg); j++ {
+				if pseg[j] == '{' {
+					depth++
+				} else if pseg[j] == '}' {
+					if depth--; depth == 0 {
+						break
 					}
 				}
 			}
-			return node.links[pexp]
+			if j == len(pseg) {
+				j--
+			}
+			pse = pseg[i : j+1]
+			i = j
 		}
+		pses = append(pses, pse)
+		at = i + 1
 	}
-	return node.findLink(pseg)
+	return append(text, pseg[at:]), pses
 }
 
-// FindHandler returns a resource handler that matches the requested route; or
-// an error (StatusError) if none found.
-// method is the HTTP verb.
-// path is the relative URI path.
-// values is a pointer to an url.Values map to store parameters from the path.
-func (router *trieRegexpRouter) FindHandler(method, path string, values *url.Values) (HandlerFunc, error) {
-	if method == "HEAD" {
-		method = "GET"
+// pseName returns the varname of the PSE 'pse'; "wild" for "*". Custom regexp's
+// have the name of their first named group, or "" if none.
+func pseName(pse string) string {
+	switch {
+	case pse == "*":
+		return "wild"
+	case strings.HasPrefix(pse, "{re:") || strings.HasPrefix(pse, "{rei:"):
+		if m := pseGroupExp.FindStringSubmatch(pse); m != nil {
+			return m[1]
+		}
+	default:
+		if m := pseNameExp.FindStringSubmatch(pse); m != nil {
+			return m[2]
+		}
 	}
-	node := router.root
-	pseg := strings.Split(method+strings.TrimRight(path, "/"), "/") // ex: GET/api/users
-	slen := len(pseg)
-	for i := range make([]struct{}, slen) {
-		if node == nil {
-			if i <= 1 {
-				return nil, ErrRouteBadMethod
+	return ""
+}
+
+// ParamNames returns the varnames of the PSE's of the route of 'method' and
+// 'path', as they were given to AddRoute, in order; the host PSE's first. A
+// PSE without varname, like a custom regexp without named groups, is named by
+// position as "_1", "_2", ..., as in URL. Components of a value, like the year
+// of a {date:varname}, are not listed. It returns nil if the route doesn't
+// exist or has no PSE's.
+func (router *TrieRegexpRouter) ParamNames(method, path string) []string {
+	router.rlock()
+	defer router.runlock()
+	nodes, _, _ := router.walkRoute(strings.ToUpper(method), path, false)
+	if nodes == nil || nodes[len(nodes)-1].handler == nil {
+		return nil
+	}
+	var names []string
+	for _, node := range nodes {
+		if node.exp == "" {
+			continue
+		}
+		_, pses := splitPSE(node.pseg)
+		for _, pse := range pses {
+			name := pseName(pse)
+			if name == "" {
+				name = "_" + strconv.Itoa(len(names)+1)
 			}
-			return nil, ErrRouteNotFound
+			names = append(names, name)
 		}
-		node = node.matchSegment(pseg[i], slen, values)
 	}
+	return names
+}
 
-	if node == nil || node.handler == nil {
-		return nil, ErrRouteNotFound
+// RouteInfo describes a route of the router, see ListRoutes.
+// Method is the HTTP method of the route, in upper case.
+// Pattern is the route path, with its PSE's as they were given to AddRoute;
+// and its host, if any, as "//host/path".
+// Handler is the resource handler of the route.
+// Name is the name of the route path given to AddNamedRoute, or "" if none.
+type RouteInfo struct {
+	Method  string
+	Pattern string
+	Handler HandlerFunc
+	Name    string
+}
+
+// ListRoutes returns the routes of the router; the routes without a host first,
+// by method in the order they were first used, and then the host routes. The
+// routes of each method are listed depth-first in the order they are matched.
+func (router *TrieRegexpRouter) ListRoutes() []RouteInfo {
+	router.rlock()
+	defer router.runlock()
+	names := make(map[string]string, len(router.names))
+	for name, path := range router.names {
+		names[path] = name
 	}
-	return node.handler, nil
+	var routes []RouteInfo
+	router.walk(func(method, pattern string, node *trieNode) bool {
+		routes = append(routes, RouteInfo{
+			Method:  method,
+			Pattern: pattern,
+			Handler: node.handler,
+			Name:    names[pattern],
+		})
+		return true
+	})
+	return routes
 }
 
-// PathMethods returns a string with comma-separated HTTP methods that match
-// the path. This list is suitable for Allow header response. Note that this
-// function only lists the methods, not if they are allowed.
-func (router *trieRegexpRouter) PathMethods(path string) string {
-	var node *trieNode
-	methods := "HEAD" // cheat
-	pseg := strings.Split("*"+strings.TrimRight(path, "/"), "/")
-	slen := len(pseg)
-	for _, method := range router.methods {
-		node = router.root
-		pseg[0] = method
-		for i := range pseg {
-			if node == nil {
-				continue
+// Walk calls 'fn' for each route of the router, in the order of ListRoutes,
+// with its method, pattern and handler; without making a list. The walk stops
+// early if fn returns false. The router is locked for reading during the walk,
+// so fn must not change the routes.
+func (router *TrieRegexpRouter) Walk(fn func(method, pattern string, handler HandlerFunc) bool) {
+	router.rlock()
+	defer router.runlock()
+	router.walk(func(method, pattern string, node *trieNode) bool {
+		return fn(method, pattern, node.handler)
+	})
+}
+
+// walk calls 'fn' for each route in the tree, as in ListRoutes, with its
+// method, its pattern and its route node. The walk stops if fn returns false.
+func (router *TrieRegexpRouter) walk(fn func(method, pattern string, node *trieNode) bool) {
+	for _, link := range router.root.links {
+		if !link.walk(link.pseg, "", fn) {
+			return
+		}
+	}
+	if router.hosts == nil {
+		return
+	}
+	for _, host := range router.hosts.links {
+		for _, link := range host.links {
+			if !link.walk(link.pseg, "//"+host.pseg, fn) {
+				return
 			}
-			node = node.matchSegment(pseg[i], slen, nil)
 		}
-		if node == nil || node.handler == nil {
+	}
+}
+
+// walk calls 'fn' for the route of the node, if any, and then for the routes of
+// its links; the patterns begin with 'path'. It returns false if fn does.
+// The node of an optional PSE route is reported with the PSE as it was given,
+// and its parent node, which has the same route, is skipped.
+func (n *trieNode) walk(method, path string, fn func(method, pattern string, node *trieNode) bool) bool {
+	if n.handler != nil && !n.hasOptional() {
+		pattern := path
+		if pattern == "" || strings.HasPrefix(pattern, "//") && !strings.Contains(pattern[2:], "/") {
+			pattern += "/"
+		}
+		if !fn(method, pattern, n) {
+			return false
+		}
+	}
+	for _, link := range n.links {
+		pseg := link.pseg
+		if link.optional != "" {
+			pseg = link.optional
+		}
+		if !link.walk(method, path+"/"+pseg, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasOptional returns true if the node has a link with an optional PSE route,
+// which the node's route is part of.
+func (n *trieNode) hasOptional() bool {
+	for _, link := range n.links {
+		if link.optional != "" && link.handler != nil && link.route == n.route {
+			return true
+		}
+	}
+	return false
+}
+
+// Conflict is a pair of routes that can match the same request, see
+// ConflictingRoutes. Pattern and Other are the route paths as they were given
+// to AddRoute; Pattern is the one tried first.
+type Conflict struct {
+	Method  string
+	Pattern string
+	Other   string
+}
+
+// ConflictingRoutes returns the pairs of routes of the same method, and host,
+// that can match the same request; e.g., "/api/{word:a}/x" and "/api/b/{word:c}"
+// both match "/api/b/x". The precedence rules pick one of them, which may not
+// be the one intended.
+// The check is a heuristic: a string segment overlaps a PSE if the PSE matches
+// it, and two PSE's overlap if one matches a sample value of the other. A
+// {path:varname} PSE overlaps any segments. So some conflicts between PSE's
+// may not be found.
+func (router *TrieRegexpRouter) ConflictingRoutes() []Conflict {
+	router.rlock()
+	defer router.runlock()
+	var conflicts []Conflict
+	tops := []*trieNode{router.root}
+	if router.hosts != nil {
+		tops = append(tops, router.hosts.links...)
+	}
+	for _, top := range tops {
+		for _, link := range top.links {
+			conflicts = router.findConflicts(link.pseg, link, conflicts)
+		}
+	}
+	return conflicts
+}
+
+// findConflicts appends the conflicts between the routes below each pair of
+// overlapping links of the node, and then below each link, to 'conflicts'.
+func (router *TrieRegexpRouter) findConflicts(method string, n *trieNode, conflicts []Conflict) []Conflict {
+	for i := range n.links {
+		for j := i + 1; j < len(n.links); j++ {
+			if router.overlaps(n.links[i], n.links[j]) {
+				conflicts = router.pairConflicts(method, n.links[i], n.links[j], conflicts)
+			}
+		}
+	}
+	for _, link := range n.links {
+		conflicts = router.findConflicts(method, link, conflicts)
+	}
+	return conflicts
+}
+
+// pairConflicts appends the conflicts between the routes of 'a' and 'b', which
+// overlap, and the routes below them to 'conflicts'. A {path:varname} route
+// conflicts with all the routes below the other node.
+func (router *TrieRegexpRouter) pairConflicts(method string, a, b *trieNode, conflicts []Conflict) []Conflict {
+	a, b = a.expand(), b.expand()
+	add := func(x, y *trieNode) {
+		if x.handler != nil && y.handler != nil && x.route != y.route {
+			conflicts = append(conflicts, Conflict{Method: method, Pattern: x.route, Other: y.route})
+		}
+	}
+	add(a, b)
+	for _, tail := range []*trieNode{a, b} {
+		other := b
+		if tail == b {
+			other = a
+		}
+		if !strings.Contains(tail.pseg, "{path:") {
 			continue
 		}
-		methods += ", " + method
+		var below func(n *trieNode)
+		below = func(n *trieNode) {
+			for _, link := range n.links {
+				add(tail, link)
+				below(link)
+			}
+		}
+		below(other)
 	}
-	return methods
+	for _, x := range a.links {
+		for _, y := range b.links {
+			if router.overlaps(x, y) {
+				conflicts = router.pairConflicts(method, x, y, conflicts)
+			}
+		}
+	}
+	return conflicts
+}
+
+// overlaps returns true if the segments of 'a' and 'b' may match the same
+// value, see ConflictingRoutes.
+func (router *TrieRegexpRouter) overlaps(a, b *trieNode) bool {
+	a, b = a.expand(), b.expand()
+	if strings.Contains(a.pseg, "{path:") || strings.Contains(b.pseg, "{path:") {
+		return true
+	}
+	if a.exp == "" && b.exp == "" {
+		return a.pseg == b.pseg || ((a.fold || b.fold) && strings.EqualFold(a.pseg, b.pseg))
+	}
+	if a.exp == b.exp {
+		return true
+	}
+	if a.exp == "" {
+		a, b = b, a
+	}
+	rxa := router.nodeRegexp(a)
+	if b.exp == "" {
+		return fullMatch(rxa, b.pseg)
+	}
+	rxb := router.nodeRegexp(b)
+	return fullMatch(rxa, regexpSample(rxb)) || fullMatch(rxb, regexpSample(rxa))
+}
+
+// nodeRegexp returns the compiled regexp of the regexp link 'n'.
+func (router *TrieRegexpRouter) nodeRegexp(n *trieNode) *regexp.Regexp {
+	if n.rx != nil {
+		return n.rx
+	}
+	rx, _ := router.cache.load(n.exp)
+	return rx
+}
+
+// fullMatch returns true if 'rx' matches all of 's'.
+func fullMatch(rx *regexp.Regexp, s string) bool {
+	m := rx.FindStringIndex(s)
+	return m != nil && m[0] == 0 && m[1] == len(s)
 }
 
-// newRouter returns a new trieRegexpRouter object with an initialized tree.
-func newRouter() *trieRegexpRouter {
-	return &trieRegexpRouter{root: new(trieNode)}
+// regexpSample returns a short value that 'rx' likely matches; the first
+// alternative, the first rune of each class, and the fewest repetitions.
+func regexpSample(rx *regexp.Regexp) string {
+	re, err := syntax.Parse(rx.String(), syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	var sample []rune
+	var gen func(re *syntax.Regexp)
+	gen = func(re *syntax.Regexp) {
+		switch re.Op {
+		case syntax.OpLiteral:
+			sample = append(sample, re.Rune...)
+		case syntax.OpCharClass:
+			if len(re.Rune) > 0 {
+				sample = append(sample, re.Rune[0])
+			}
+		case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+			sample = append(sample, 'a')
+		case syntax.OpCapture, syntax.OpPlus, syntax.OpAlternate:
+			gen(re.Sub[0])
+		case syntax.OpRepeat:
+			for i := 0; i < re.Min; i++ {
+				gen(re.Sub[0])
+			}
+		case syntax.OpConcat:
+			for _, sub := range re.Sub {
+				gen(sub)
+			}
+		}
+	}
+	gen(re.Simplify())
+	return string(sample)
+}
+
+// splitHost splits a path with a host, "//host/path", into host and path.
+// A path without a host is returned as is.
+func splitHost(path string) (host, rest string) {
+	if !strings.HasPrefix(path, "//") {
+		return "", path
+	}
+	host = path[2:]
+	if i := strings.Index(host, "/"); i != -1 {
+		return host[:i], host[i:]
+	}
+	return host, ""
+}
+
+// splitHost splits a path with a host, as the function splitHost, only if the
+// router has host routes. So a path that begins with "//" is not mistaken for
+// a host otherwise.
+func (router *TrieRegexpRouter) splitHost(path string) (host, rest string) {
+	if router.hosts == nil {
+		return "", path
+	}
+	return splitHost(path)
+}
+
+// hostNode returns the host node for the host pattern 'host', adding it if
+// needed. Hosts are matched case-insensitive. A host pattern is split in labels
+// on ".", and each label may have a PSE, as in path segments; e.g.,
+// "{word:tenant}.example.com" matches "acme.example.com" and the value "acme"
+// is stored in the varname "tenant". A "*" label matches one or more labels,
+// stored in the varname "wild".
+func (router *TrieRegexpRouter) hostNode(host string) *trieNode {
+	if router.hosts == nil {
+		router.hosts = new(trieNode)
+	}
+	link := router.hosts.findLink(host)
+	if link == nil {
+		link = &trieNode{pseg: host, fold: true}
+		if (strings.Contains(host, "{") && strings.Contains(host, "}")) || strings.Contains(host, "*") {
+			link.exp, link.fold = "//"+host, false
+			if rx, _ := router.cache.load(link.exp); rx == nil {
+				router.cache.store(link.exp, hostExp(host))
+			}
+			router.hosts.numExp++
+		}
+		router.hosts.addLink(link)
+	}
+	return link
+}
+
+// hostExp compiles the host pattern into a regexp that matches a whole host,
+// case-insensitive. Labels without a PSE are matched literally, custom regexp
+// PSE's are not supported. This function will panic if the regexp compilation
+// fails.
+func hostExp(pattern string) *regexp.Regexp {
+	rx, err := compileHost(pattern)
+	if err != nil {
+		panic(err.Error())
+	}
+	return rx
+}
+
+// compileHost compiles the host pattern as hostExp, but it returns an error if
+// the regexp compilation fails.
+func compileHost(pattern string) (rx *regexp.Regexp, err error) {
+	defer recoverPSE(&err)
+	labels := strings.Split(pattern, ".")
+	for i := range labels {
+		if (strings.Contains(labels[i], "{") && strings.Contains(labels[i], "}")) || strings.Contains(labels[i], "*") {
+			labels[i] = `(?:` + segmentPattern(labels[i]) + `)`
+			continue
+		}
+		labels[i] = regexp.QuoteMeta(labels[i])
+	}
+	rx, err = regexp.Compile(`^(?i:` + strings.Join(labels, `\.`) + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("relax: invalid host %q: %s", pattern, err.Error())
+	}
+	return rx, nil
+}
+
+// optionalSegment checks if 'pseg' is an optional PSE; "{type:varname?}" or
+// "{type:varname=default}". It returns the PSE without the marker, and the
+// varname and default value if one is set.
+func optionalSegment(pseg string) (pse, name, value string, ok bool) {
+	if !strings.HasPrefix(pseg, "{") || !strings.HasSuffix(pseg, "}") ||
+		strings.HasPrefix(pseg, "{re:") || strings.HasPrefix(pseg, "{rei:") {
+		return pseg, "", "", false
+	}
+	if strings.HasSuffix(pseg, "?}") {
+		return pseg[:len(pseg)-2] + "}", "", "", true
+	}
+	eq := strings.LastIndex(pseg, "=")
+	if eq == -1 {
+		return pseg, "", "", false
+	}
+	name = pseg[strings.Index(pseg, ":")+1 : eq]
+	if i := strings.IndexAny(name, ":("); i != -1 {
+		name = name[:i]
+	}
+	return pseg[:eq] + "}", name, pseg[eq+1 : len(pseg)-1], true
+}
+
+// setValues stores the submatches 'm' of a regexp with the groups 'groups' in
+// values. Only the value groups are stored, see valueGroups. Each value is
+// stored with a positional key, "_1", "_2", ..., counting the values already
+// stored; and with its name, if it has one.
+func setValues(groups []valueGroup, m []string, values *url.Values) {
+	if values == nil {
+		return
+	}
+	if *values == nil {
+		*values = make(url.Values)
+	}
+	n := 1
+	for (*values)["_"+strconv.Itoa(n)] != nil {
+		n++
+	}
+	for i := 1; i < len(m); i++ {
+		if !groups[i].value {
+			continue
+		}
+		(*values).Set("_"+strconv.Itoa(n), m[i])
+		n++
+		if groups[i].name != "" {
+			(*values).Add(groups[i].name, m[i])
+		}
+	}
+}
+
+// matchSegment tries to match a segment 'pseg' to the node's string links, and
+// then to its regexp links in order; used for the method and the host of a
+// request. The values of the regexp link that matches are stored in values.
+// Path segments are matched by findNode, which can backtrack.
+func (node *trieNode) matchSegment(cache *regexpCache, pseg string, values *url.Values) *trieNode {
+	// string segments are preferred over regexp's.
+	if link := node.findLink(pseg); link != nil || node.numExp == 0 {
+		return link
+	}
+	for _, link := range node.links[len(node.links)-node.numExp:] {
+		if link == node.tail {
+			continue
+		}
+		if m := link.match(cache, pseg); m != nil {
+			_, groups := link.compiled(cache)
+			setValues(groups, m, values)
+			return link
+		}
+	}
+	return nil
+}
+
+// uncaptured returns the expression of 'rx' without capture groups, which are
+// not needed to find the link that matches.
+func uncaptured(rx *regexp.Regexp) string {
+	re, err := syntax.Parse(rx.String(), syntax.Perl)
+	if err != nil {
+		return `(?:` + rx.String() + `)`
+	}
+	var strip func(re *syntax.Regexp) *syntax.Regexp
+	strip = func(re *syntax.Regexp) *syntax.Regexp {
+		for re.Op == syntax.OpCapture {
+			re = re.Sub[0]
+		}
+		for i := range re.Sub {
+			re.Sub[i] = strip(re.Sub[i])
+		}
+		return re
+	}
+	return strip(re).String()
+}
+
+// matchChain matches the segments after the first of a merged node, see
+// compact, to the next segments of 'it'. It returns true if they all match,
+// or if the node isn't merged.
+func (node *trieNode) matchChain(it *pathIter) bool {
+	i := strings.IndexByte(node.pseg, '/')
+	if i == -1 || node.exp != "" {
+		return true
+	}
+	chain := node.pseg[i+1:]
+	for {
+		seg, ok := it.next()
+		if !ok {
+			return false
+		}
+		i = strings.IndexByte(chain, '/')
+		if i == -1 {
+			return seg == chain
+		}
+		if seg != chain[:i] {
+			return false
+		}
+		chain = chain[i+1:]
+	}
+}
+
+// compiled returns the compiled regexp of the regexp link 'n', and its value
+// groups; from 'cache' if the link doesn't have them.
+func (n *trieNode) compiled(cache *regexpCache) (*regexp.Regexp, []valueGroup) {
+	if n.rx != nil {
+		return n.rx, n.groups
+	}
+	return cache.load(n.exp)
+}
+
+// match returns the submatches of the regexp of the regexp link 'n' in 's',
+// if it matches the whole of s, and the matchers of its groups accept their
+// values; or nil.
+func (n *trieNode) match(cache *regexpCache, s string) []string {
+	rx, groups := n.compiled(cache)
+	m := rx.FindStringSubmatch(s)
+	if len(m) < 2 || m[0] != s {
+		return nil
+	}
+	for i := range groups {
+		if groups[i].typ == nil {
+			continue
+		}
+		if fn := groups[i].typ.matcher(); fn != nil && !fn(m[i]) {
+			return nil
+		}
+	}
+	return m
+}
+
+// matchFrame is a node in the stack of findNode. it is the rest of the path
+// after the node's segment, and m the submatches of the segment if the node
+// is a regexp link. seg and after are the next segment and the rest of the
+// path after it, set when the node's links are first tried. next is the next
+// link to try: 0 is the string link, 1 to numExp the regexp links in order,
+// and numExp+1 the tail link.
+type matchFrame struct {
+	node  *trieNode
+	it    pathIter
+	m     []string
+	seg   string
+	after pathIter
+	next  int
+}
+
+// nextLink returns the next link of the frame's node that matches the segment
+// f.seg, after the ones already tried; with its submatches, if it's a regexp
+// link, and the rest of the path after it. It returns nil if none is left.
+// String links are tried first, and the tail link last, against all the rest
+// of the path. A link without links is skipped if the segment isn't the last.
+func (f *matchFrame) nextLink(cache *regexpCache) (*trieNode, []string, pathIter) {
+	node := f.node
+	if f.next == 0 {
+		f.after = f.it
+		f.seg, _ = f.after.next()
+		f.next = 1
+		if link := node.findLink(f.seg); link != nil {
+			after := f.after
+			if link.matchChain(&after) {
+				return link, nil, after
+			}
+		}
+	}
+	first := len(node.links) - node.numExp
+	if f.next == 1 && node.combined != nil {
+		// the links before the first alternative that matches can't match.
+		c := node.combined
+		f.next = node.numExp + 1
+		if m := c.rx.FindStringSubmatchIndex(f.seg); m != nil {
+			for i, mark := range c.marks {
+				if m[2*mark] >= 0 {
+					f.next = c.links[i] - first + 1
+					break
+				}
+			}
+		}
+	}
+	for ; f.next <= node.numExp; f.next++ {
+		link := node.links[first+f.next-1]
+		if link == node.tail || (link.links == nil && !f.after.done) {
+			continue
+		}
+		if m := link.match(cache, f.seg); m != nil {
+			f.next++
+			return link, m, f.after
+		}
+	}
+	if f.next == node.numExp+1 {
+		f.next++
+		if node.tail != nil {
+			if m := node.tail.match(cache, f.it.join()); m != nil {
+				return node.tail, m, pathIter{done: true}
+			}
+		}
+	}
+	return nil, nil, pathIter{}
+}
+
+// findNode walks the tree from node matching the method, and then the segments
+// of the escaped 'path', see pathIter. It returns the route node that matched
+// the last segment; or nil if none did.
+// A route with only string segments is found with one lookup in the statics of
+// the method node, without the walk; the walk ends in the same node, since
+// string links are preferred. Paths with escapes are walked, to be decoded.
+// The links of each node are tried in order, see nextLink. If the path can't
+// be matched below a link, the walk backtracks and tries the next one; so the
+// first route in that order is found. The walk keeps a stack of the nodes
+// matched, not recursion. The values of the route's PSE's are stored in
+// 'values' when it's found, not the ones of the links tried before.
+func (node *trieNode) findNode(cache *regexpCache, method, path string, values *url.Values) *trieNode {
+	it := newPathIter(path)
+	node = node.matchSegment(cache, method, values)
+	if node == nil {
+		return nil
+	}
+	if node.statics != nil && strings.IndexByte(path, '%') == -1 {
+		if link := node.statics[strings.TrimRight(path, "/")]; link != nil && link.handler != nil {
+			return link
+		}
+	}
+	var buf [16]matchFrame
+	stack := append(buf[:0], matchFrame{node: node, it: it})
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.it.done {
+			if f.node.handler != nil {
+				break
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		link, m, after := f.nextLink(cache)
+		if link == nil {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		stack = append(stack, matchFrame{node: link, it: after, m: m})
+	}
+	if len(stack) == 0 {
+		return nil
+	}
+	if values != nil {
+		for _, f := range stack[1:] {
+			if f.m == nil {
+				continue
+			}
+			_, groups := f.node.compiled(cache)
+			setValues(groups, f.m, values)
+		}
+	}
+	return stack[len(stack)-1].node
+}
+
+// findNode matches the method and path in the routes of 'host', if any, and
+// then in the routes without a host. See trieNode.findNode.
+func (router *TrieRegexpRouter) findNode(host, method, path string, values *url.Values) *trieNode {
+	if host != "" && router.hosts != nil {
+		var hv url.Values
+		if link := router.hosts.matchSegment(router.cache, host, &hv); link != nil {
+			if node := link.findNode(router.cache, method, path, &hv); node != nil && node.handler != nil {
+				if values != nil {
+					if *values == nil {
+						*values = make(url.Values)
+					}
+					for k, v := range hv {
+						(*values)[k] = append((*values)[k], v...)
+					}
+				}
+				return node
+			}
+		}
+	}
+	return router.root.findNode(router.cache, method, path, values)
+}
+
+// cleanSlashes returns 'path' with repeated "/" collapsed into one.
+func cleanSlashes(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.Replace(path, "//", "/", -1)
+	}
+	return path
+}
+
+// cleanDots returns 'path' without its "." segments, and without each ".."
+// segment and the segment before it; the dots may be encoded, "%2E". ".."
+// segments at the root are removed. A path without dot segments is returned
+// as is.
+func cleanDots(path string) string {
+	if !strings.Contains(path, ".") && !strings.Contains(path, "%2") {
+		return path
+	}
+	segs := strings.Split(path, "/")
+	clean := segs[:0]
+	dots := false
+	for _, seg := range segs {
+		switch dotSegment(seg) {
+		case ".":
+			dots = true
+		case "..":
+			dots = true
+			if n := len(clean); n > 1 || n == 1 && clean[0] != "" {
+				clean = clean[:n-1]
+			}
+		default:
+			clean = append(clean, seg)
+		}
+	}
+	if !dots {
+		return path
+	}
+	if len(clean) == 1 && clean[0] == "" {
+		return "/"
+	}
+	return strings.Join(clean, "/")
+}
+
+// dotSegment returns the segment 'seg' if it is "." or "..", decoded; or "".
+func dotSegment(seg string) string {
+	if len(seg) == 0 || len(seg) > 6 || seg[0] != '.' && seg[0] != '%' {
+		return ""
+	}
+	seg = strings.Replace(strings.Replace(seg, "%2e", ".", -1), "%2E", ".", -1)
+	if seg == "." || seg == ".." {
+		return seg
+	}
+	return ""
+}
+
+// pathSegments splits the method and path into segments, the method is the
+// first segment. A path without a leading slash is relative to the root, and
+// trailing slashes are ignored. So the root path "/", and an empty path, is the
+// method segment alone; the route node of "GET /" is the "GET" node.
+// The segments share the storage of 'path', which the route node keeps; so
+// the nodes don't keep another copy of the path.
+func pathSegments(method, path string) []string {
+	path = strings.TrimRight(path, "/")
+	if path == "" {
+		return []string{method}
+	}
+	if path[0] == '/' {
+		path = path[1:]
+	}
+	pseg := make([]string, 1, strings.Count(path, "/")+2)
+	pseg[0] = method
+	for {
+		i := strings.IndexByte(path, '/')
+		if i == -1 {
+			return append(pseg, path)
+		}
+		pseg, path = append(pseg, path[:i]), path[i+1:]
+	}
+}
+
+// pathIter iterates the segments of an escaped path, as pathSegments splits
+// them, without making a list; so requests are matched without allocations.
+// Each segment is decoded after the split, so an encoded "/", "%2F", doesn't
+// separate segments. Segments that can't be decoded are used as is.
+// rest is the path left after the last segment returned.
+type pathIter struct {
+	rest string
+	done bool
+}
+
+// newPathIter returns a pathIter for the segments of 'path'.
+func newPathIter(path string) pathIter {
+	path = strings.TrimRight(path, "/")
+	if path == "" {
+		return pathIter{done: true}
+	}
+	if path[0] == '/' {
+		path = path[1:]
+	}
+	return pathIter{rest: path}
+}
+
+// next returns the next segment, decoded; ok is false if there are no more.
+func (it *pathIter) next() (seg string, ok bool) {
+	if it.done {
+		return "", false
+	}
+	if i := strings.IndexByte(it.rest, '/'); i != -1 {
+		seg, it.rest = it.rest[:i], it.rest[i+1:]
+	} else {
+		seg, it.rest, it.done = it.rest, "", true
+	}
+	if strings.IndexByte(seg, '%') != -1 {
+		if dec, err := url.PathUnescape(seg); err == nil {
+			seg = dec
+		}
+	}
+	return seg, true
+}
+
+// join returns the segments left, decoded, joined with "/".
+func (it pathIter) join() string {
+	var b strings.Builder
+	for seg, ok := it.next(); ok; seg, ok = it.next() {
+		b.WriteString(seg)
+		if !it.done {
+			b.WriteByte('/')
+		}
+	}
+	return b.String()
+}
+
+// FindHandler returns a resource handler that matches the requested route; or
+// an error (StatusError) if none found. The error is ErrRouteBadMethod if the
+// path has routes with other methods, a copy with the allowed methods that is
+// matched with errors.Is; ErrRouteNotFound otherwise.
+// If a not found handler is set, it is
+// returned instead of ErrRouteNotFound; and if a method not allowed handler is
+// set, it is returned instead of ErrRouteBadMethod. See SetNotFoundHandler and
+// SetMethodNotAllowedHandler.
+// An OPTIONS request to a path without an OPTIONS route, but that has routes
+// with other methods, is answered by a handler that lists them in an Allow header.
+// method is the HTTP verb, matched case-insensitive.
+// path is the relative URI path, escaped as in url.URL.EscapedPath; with the
+// request host as "//host/path" to match host routes too. The path segments are
+// decoded before matching, and the decoded values are stored in 'values'.
+// A path with a NUL byte or another control character, as is or encoded like
+// "%00", is not matched; the error is ErrRouteBadPath.
+// values is a pointer to an url.Values map to store parameters from the path.
+func (router *TrieRegexpRouter) FindHandler(method, path string, values *url.Values) (HandlerFunc, error) {
+	router.rlock()
+	defer router.runlock()
+	return router.findHandler(method, path, values)
+}
+
+// findHandler finds a handler as FindHandler, with the router already locked.
+func (router *TrieRegexpRouter) findHandler(method, path string, values *url.Values) (HandlerFunc, error) {
+	if router.MaxPathLength > 0 && len(path) > router.MaxPathLength {
+		return nil, ErrRouteTooLong
+	}
+	if router.MaxSegments > 0 {
+		if _, rest := router.splitHost(path); countSegments(rest) > router.MaxSegments {
+			return nil, ErrRouteTooLong
+		}
+	}
+	if hasControl(path) {
+		return nil, ErrRouteBadPath
+	}
+	if router.MaxSegmentLength > 0 && hasLongSegment(path, router.MaxSegmentLength) {
+		return router.notFoundHandler()
+	}
+	method = strings.ToUpper(method)
+	host, rest := router.splitHost(path)
+	prefix := path[:len(path)-len(rest)]
+	clean := cleanSlashes(rest)
+	if router.CleanDotSegments {
+		clean = cleanDots(clean)
+	}
+	if clean != rest {
+		if router.RedirectCleanPath {
+			canonical := clean
+			if router.RedirectTrailingSlash && len(canonical) > 1 {
+				canonical = strings.TrimRight(canonical, "/")
+			}
+			if router.isRouted(host, method, canonical) {
+				return nil, redirectError(method, canonical)
+			}
+		}
+		rest, path = clean, prefix+clean
+	}
+	if router.RedirectTrailingSlash && len(rest) > 1 && strings.HasSuffix(rest, "/") {
+		canonical := strings.TrimRight(rest, "/")
+		if canonical == "" {
+			canonical = "/"
+		}
+		if router.isRouted(host, method, canonical) {
+			return nil, redirectError(method, canonical)
+		}
+	}
+	// HEAD is routed to GET, unless it has its own route.
+	if method == "HEAD" {
+		if node := router.findNode(host, method, rest, nil); node == nil || node.handler == nil {
+			method = "GET"
+		}
+	}
+	node := router.findNode(host, method, rest, values)
+	if node == nil || node.handler == nil {
+		methods := router.pathMethods(path)
+		// answer OPTIONS for any path that has routes, if not routed already.
+		if method == "OPTIONS" && methods != nil {
+			methods = allowMethods(append(methods, "OPTIONS"))
+			return optionsHandler(strings.Join(methods, ", ")), nil
+		}
+		// the path is routed, but not with this method.
+		if methods != nil {
+			allow := strings.Join(allowMethods(methods), ", ")
+			if router.badMethod != nil {
+				return allowHandler(allow, router.badMethod), nil
+			}
+			return nil, badMethodError(allow)
+		}
+		return router.notFoundHandler()
+	}
+	if values != nil {
+		if *values == nil {
+			*values = make(url.Values)
+		}
+		for k, v := range node.defaults {
+			if _, ok := (*values)[k]; !ok {
+				(*values)[k] = v
+			}
+		}
+		(*values).Set(RoutePattern, node.route)
+	}
+	return node.handler, nil
+}
+
+// isRouted returns true if the path 'rest' of 'host' has a route for 'method',
+// or for GET if the method is HEAD; the redirects check their target with it.
+// The handlers for paths without a route don't count, like the not found one.
+func (router *TrieRegexpRouter) isRouted(host, method, rest string) bool {
+	node := router.findNode(host, method, rest, nil)
+	if (node == nil || node.handler == nil) && method == "HEAD" {
+		node = router.findNode(host, "GET", rest, nil)
+	}
+	return node != nil && node.handler != nil
+}
+
+// notFoundHandler returns the not found handler, if set; or ErrRouteNotFound.
+func (router *TrieRegexpRouter) notFoundHandler() (HandlerFunc, error) {
+	if router.notFound != nil {
+		return router.notFound, nil
+	}
+	return nil, ErrRouteNotFound
+}
+
+// hasControl returns true if 'path' has a control character, 0x00 to 0x1F or
+// 0x7F, as is or percent-encoded; e.g., "%00".
+func hasControl(path string) bool {
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '%' && i+2 < len(path) {
+			if h, ok := unhex(path[i+1]); ok {
+				if l, ok := unhex(path[i+2]); ok {
+					c = h<<4 | l
+				}
+			}
+		}
+		if c < 0x20 || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// unhex returns the value of the hex digit 'c', and true if it is one.
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// countSegments returns the number of segments of 'path', without a host; e.g.,
+// "/api/users/5" has 3, and "/" none.
+func countSegments(path string) int {
+	if path = strings.Trim(path, "/"); path == "" {
+		return 0
+	}
+	return strings.Count(path, "/") + 1
+}
+
+// hasLongSegment returns true if 'path' has a segment longer than 'max'.
+func hasLongSegment(path string, max int) bool {
+	for len(path) > max {
+		i := strings.IndexByte(path, '/')
+		if i == -1 || i > max {
+			return true
+		}
+		path = path[i+1:]
+	}
+	return false
+}
+
+// SetNotFoundHandler sets the handler that FindHandler returns for requests
+// that don't match a route, instead of ErrRouteNotFound; e.g., to serve an
+// index page or a custom error. A nil handler restores the error.
+func (router *TrieRegexpRouter) SetNotFoundHandler(handler HandlerFunc) {
+	router.lock()
+	router.notFound = handler
+	router.mu.Unlock()
+}
+
+// SetMethodNotAllowedHandler sets the handler that FindHandler returns for
+// requests with a method that the path doesn't have, instead of
+// ErrRouteBadMethod. The Allow header is set with the path methods before the
+// handler is called. A nil handler restores the error.
+func (router *TrieRegexpRouter) SetMethodNotAllowedHandler(handler HandlerFunc) {
+	router.lock()
+	router.badMethod = handler
+	router.mu.Unlock()
+}
+
+// PathMethods returns a string with comma-separated HTTP methods that match
+// the path, sorted and without duplicates. This list is suitable for Allow
+// header response. HEAD is listed if GET or HEAD is, since HEAD requests are
+// routed to GET without a HEAD route. Note that this function only lists the
+// methods, not if they are allowed.
+func (router *TrieRegexpRouter) PathMethods(path string) string {
+	return strings.Join(router.PathMethodsSlice(path), ", ")
+}
+
+// PathMethodsSlice returns the list of HTTP methods that match the path, as
+// in PathMethods.
+func (router *TrieRegexpRouter) PathMethodsSlice(path string) []string {
+	router.rlock()
+	defer router.runlock()
+	return allowMethods(router.pathMethods(path))
+}
+
+// hasHosts returns true if the router has routes with a host.
+func (router *TrieRegexpRouter) hasHosts() bool {
+	router.rlock()
+	defer router.runlock()
+	return router.hosts != nil
+}
+
+// allowMethods returns 'methods' sorted and without duplicates. HEAD is added
+// if GET is in the list.
+func allowMethods(methods []string) []string {
+	set := make(map[string]bool, len(methods)+1)
+	for i := range methods {
+		set[methods[i]] = true
+	}
+	if set["GET"] {
+		set["HEAD"] = true
+	}
+	list := make([]string, 0, len(set))
+	for method := range set {
+		list = append(list, method)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// pathMethods returns the list of methods that have a route matching path,
+// or nil if none.
+func (router *TrieRegexpRouter) pathMethods(path string) []string {
+	var methods []string
+	host, path := router.splitHost(path)
+	for _, method := range router.methods {
+		node := router.findNode(host, method, path, nil)
+		if node == nil || node.handler == nil {
+			continue
+		}
+		methods = append(methods, method)
+	}
+	return methods
+}
+
+// allowHandler returns a handler that sets the Allow header with 'allow', and
+// then calls 'handler'.
+func allowHandler(allow string, handler HandlerFunc) HandlerFunc {
+	return func(ctx *Context) {
+		ctx.Header().Set("Allow", allow)
+		handler(ctx)
+	}
+}
+
+// optionsHandler returns a handler that responds to OPTIONS requests with
+// the methods in 'allow', and no content.
+func optionsHandler(allow string) HandlerFunc {
+	return func(ctx *Context) {
+		ctx.Header().Set("Allow", allow)
+		ctx.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// lock locks the router for writing. This function will panic if the router is
+// frozen.
+func (router *TrieRegexpRouter) lock() {
+	if router.frozen {
+		panic("relax: router is frozen")
+	}
+	router.mu.Lock()
+}
+
+// rlock locks the router for reading, unless it's frozen.
+func (router *TrieRegexpRouter) rlock() {
+	if !router.frozen {
+		router.mu.RLock()
+	}
+}
+
+// runlock undoes a rlock.
+func (router *TrieRegexpRouter) runlock() {
+	if !router.frozen {
+		router.mu.RUnlock()
+	}
+}
+
+// Freeze returns an immutable snapshot of the router, for serving requests
+// without locks once all routes are added. The regexp links of the snapshot
+// have their compiled regexp's, so matching doesn't go through the cache; and
+// chains of string segments are merged into one node, see compact.
+// Changes to the router afterward don't affect the snapshot. Adding, replacing
+// or deleting a route in the snapshot, or setting its handlers, will panic.
+//
+//	frozen := router.Freeze()
+//	handler, err := frozen.FindHandler("GET", "/api/users/123", &values)
+func (router *TrieRegexpRouter) Freeze() Router {
+	router.rlock()
+	defer router.runlock()
+	frozen := router.clone()
+	frozen.frozen = true
+	frozen.root.freeze(frozen.cache)
+	for _, method := range frozen.root.links {
+		method.compact()
+		method.indexStatics()
+	}
+	if frozen.hosts != nil {
+		frozen.hosts.freeze(frozen.cache)
+		for _, host := range frozen.hosts.links {
+			for _, method := range host.links {
+				method.compact()
+				method.indexStatics()
+			}
+		}
+	}
+	return frozen
+}
+
+// Clone returns a copy of the router that can be changed without affecting
+// this one; e.g., to rebuild the routes in the background and then swap the
+// served router. The clone of a frozen router is not frozen.
+func (router *TrieRegexpRouter) Clone() Router {
+	router.rlock()
+	defer router.runlock()
+	return router.clone()
+}
+
+// clone returns a deep copy of the router's tree and lists. The handlers and
+// the cache are shared; the cache is safe for concurrent use and its keys
+// don't depend on the router. The copy is not frozen.
+func (router *TrieRegexpRouter) clone() *TrieRegexpRouter {
+	clone := &TrieRegexpRouter{
+		methods:               append([]string(nil), router.methods...),
+		names:                 make(map[string]string, len(router.names)),
+		notFound:              router.notFound,
+		badMethod:             router.badMethod,
+		cache:                 router.cache,
+		RedirectTrailingSlash: router.RedirectTrailingSlash,
+		RedirectCleanPath:     router.RedirectCleanPath,
+		CleanDotSegments:      router.CleanDotSegments,
+		CaseInsensitive:       router.CaseInsensitive,
+		CombineRegexps:        router.CombineRegexps,
+		MaxSegmentLength:      router.MaxSegmentLength,
+		MaxPathLength:         router.MaxPathLength,
+		MaxSegments:           router.MaxSegments,
+	}
+	for name, path := range router.names {
+		clone.names[name] = path
+	}
+	clone.root = router.root.clone()
+	for _, method := range clone.root.links {
+		method.indexStatics()
+	}
+	if router.hosts != nil {
+		clone.hosts = router.hosts.clone()
+		for _, host := range clone.hosts.links {
+			for _, method := range host.links {
+				method.indexStatics()
+			}
+		}
+	}
+	return clone
+}
+
+// clone returns a deep copy of the node and its links. Merged nodes are
+// copied as they were before compact.
+func (n *trieNode) clone() *trieNode {
+	n = n.expand()
+	clone := &trieNode{
+		pseg:     n.pseg,
+		exp:      n.exp,
+		fold:     n.fold,
+		handler:  n.handler,
+		numExp:   n.numExp,
+		depth:    n.depth,
+		optional: n.optional,
+		route:    n.route,
+		combined: n.combined,
+	}
+	if n.defaults != nil {
+		clone.defaults = make(url.Values, len(n.defaults))
+		for k, v := range n.defaults {
+			clone.defaults[k] = append([]string(nil), v...)
+		}
+	}
+	if n.links != nil {
+		clone.links = make([]*trieNode, len(n.links))
+		for i := range n.links {
+			clone.links[i] = n.links[i].clone()
+			if n.links[i] == n.tail {
+				clone.tail = clone.links[i]
+			}
+			if clone.links[i].exp == "" {
+				if clone.static == nil {
+					clone.static = make(map[string]*trieNode, len(n.static))
+				}
+				if key := staticKey(clone.links[i]); clone.static[key] == nil {
+					clone.static[key] = clone.links[i]
+				}
+			}
+		}
+	}
+	return clone
+}
+
+// freeze sets the compiled regexp's of the node's regexp links from 'cache',
+// as in all the links below.
+func (n *trieNode) freeze(cache *regexpCache) {
+	for _, link := range n.links {
+		if link.exp != "" {
+			link.rx, link.groups = cache.load(link.exp)
+		}
+		link.freeze(cache)
+	}
+}
+
+// indexStatics sets the statics of the method node from the routes below it,
+// see trieNode.
+func (n *trieNode) indexStatics() {
+	n.statics = nil
+	var index func(node *trieNode, path string)
+	index = func(node *trieNode, path string) {
+		if node.exp != "" {
+			return
+		}
+		if node.handler != nil {
+			if n.statics == nil {
+				n.statics = make(map[string]*trieNode)
+			}
+			n.statics[path] = node
+		}
+		for _, link := range node.links {
+			index(link, path+"/"+link.pseg)
+		}
+	}
+	index(n, "")
+}
+
+// compact merges each chain of string links below the node, whose nodes have
+// one string link each and no route, into the first node of the chain. So a
+// long static prefix, like "/api/internal/v2/admin", is one node that takes
+// one lookup to match; see matchChain. Case-insensitive links aren't merged.
+// The node itself, a method, isn't merged.
+func (n *trieNode) compact() {
+	for _, link := range n.links {
+		for link.exp == "" && !link.fold && link.handler == nil && len(link.links) == 1 {
+			next := link.links[0]
+			if next.exp != "" || next.fold {
+				break
+			}
+			pseg := link.pseg + "/" + next.pseg
+			*link = *next
+			link.pseg = pseg
+		}
+		link.compact()
+	}
+}
+
+// expand returns the node as it was before compact, if it's a merged node: a
+// node for its first segment, with one link for the rest of the chain. The
+// nodes are copies, not in the tree. Otherwise it returns the node itself.
+func (n *trieNode) expand() *trieNode {
+	i := strings.IndexByte(n.pseg, '/')
+	if i == -1 || n.exp != "" {
+		return n
+	}
+	rest := *n
+	rest.pseg = n.pseg[i+1:]
+	return &trieNode{
+		pseg:   n.pseg[:i],
+		depth:  n.depth - strings.Count(rest.pseg, "/") - 1,
+		links:  []*trieNode{&rest},
+		static: map[string]*trieNode{staticKey(&rest): &rest},
+	}
+}
+
+// newRouter returns a new TrieRegexpRouter object with an initialized tree.
+func newRouter() *TrieRegexpRouter {
+	return &TrieRegexpRouter{root: new(trieNode), cache: newRegexpCache()}
+}
+
+/*
+NewRouter returns a new TrieRegexpRouter, the routing engine used by a Service;
+so the router can be used without a Service, as with RouterHandler:
+
+	router := relax.NewRouter()
+	router.AddRoute("GET", "/users/{uint:id}", handler)
+	log.Fatal(http.ListenAndServe(":8000", &relax.RouterHandler{Router: router}))
+
+The router has other methods than those of Router, like AddRouteErr, Freeze and
+ListRoutes, and options; e.g., router.RedirectTrailingSlash = true.
+*/
+func NewRouter() *TrieRegexpRouter {
+	return newRouter()
+}
+
+// RouteGroup adds routes to a router under a shared path prefix.
+type RouteGroup struct {
+	router Router
+	prefix string
+}
+
+// Group returns a RouteGroup that adds routes to this router under 'prefix'.
+// For example, Group("/api/v1").AddRoute("GET", "/users", h) adds the route
+// "GET /api/v1/users". The prefix may be a host route, "//host/path".
+func (router *TrieRegexpRouter) Group(prefix string) *RouteGroup {
+	return &RouteGroup{router: router, prefix: groupPath("", prefix)}
+}
+
+// Group returns a RouteGroup nested in this one, that adds routes under
+// 'prefix' appended to this group's prefix.
+func (group *RouteGroup) Group(prefix string) *RouteGroup {
+	return &RouteGroup{router: group.router, prefix: groupPath(group.prefix, prefix)}
+}
+
+// AddRoute adds a route to the router with the group prefix before 'path'.
+// See Router.AddRoute.
+func (group *RouteGroup) AddRoute(method, path string, handler HandlerFunc) {
+	group.router.AddRoute(method, groupPath(group.prefix, path), handler)
+}
+
+// groupPath joins 'prefix' and 'path' with a single "/" between them. The
+// result always begins with "/", and a "//" of a host prefix is kept.
+func groupPath(prefix, path string) string {
+	if prefix == "" && strings.HasPrefix(path, "//") {
+		return strings.TrimRight(path, "/")
+	}
+	return strings.TrimRight(prefix, "/") + "/" + strings.Trim(path, "/")
+}
+
+// dateValueExp matches a value of a {date:varname} PSE, with its components
+// in the groups "date_year", "date_mon" and "date_mday".
+var dateValueExp = segmentExp("{date:date}")
+
+// pathValue returns the first value of 'name' in the path values, or an error
+// if there is none.
+func pathValue(values url.Values, name string) (string, error) {
+	v := values.Get(name)
+	if v == "" {
+		return "", fmt.Errorf("relax: no path value %q", name)
+	}
+	return v, nil
+}
+
+// GetInt returns the value of 'name' in the path values, as matched by an
+// {int:varname} PSE, as an int64. An error is returned if there is no value,
+// or it is not an integer; or it overflows, see strconv.ErrRange.
+//
+//	id, err := relax.GetInt(ctx.PathValues, "id")
+func GetInt(values url.Values, name string) (int64, error) {
+	v, err := pathValue(values, name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("relax: path value %q: %w", name, err)
+	}
+	return n, nil
+}
+
+// GetUint returns the value of 'name' in the path values, as matched by a
+// {uint:varname} PSE, as an uint64. See GetInt.
+func GetUint(values url.Values, name string) (uint64, error) {
+	v, err := pathValue(values, name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("relax: path value %q: %w", name, err)
+	}
+	return n, nil
+}
+
+// GetFloat returns the value of 'name' in the path values, as matched by a
+// {float:varname} or {sci:varname} PSE, as a float64. See GetInt.
+func GetFloat(values url.Values, name string) (float64, error) {
+	v, err := pathValue(values, name)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("relax: path value %q: %w", name, err)
+	}
+	return f, nil
+}
+
+// GetUUID returns the value of 'name' in the path values, as matched by an
+// {uuid:varname} PSE, as the 16 bytes of the UUID; with or without dashes.
+// The bytes convert to the UUID types of most packages; e.g., uuid.UUID(b).
+func GetUUID(values url.Values, name string) ([16]byte, error) {
+	var b [16]byte
+	v, err := pathValue(values, name)
+	if err != nil {
+		return b, err
+	}
+	h := strings.Replace(v, "-", "", -1)
+	if len(h) != 32 {
+		return b, fmt.Errorf("relax: path value %q is not an UUID: %q", name, v)
+	}
+	for i := range b {
+		n, err := strconv.ParseUint(h[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return b, fmt.Errorf("relax: path value %q is not an UUID: %q", name, v)
+		}
+		b[i] = byte(n)
+	}
+	return b, nil
+}
+
+// GetDate returns the value of 'name' in the path values, as matched by a
+// {date:varname} or {datestrict:varname} PSE, as a time.Time in UTC. A date
+// without the month or day, "YYYY" or "YYYY-MM", is the first of the year or
+// month. The time of day, if any, is not parsed; the date is at 00:00.
+//
+//	from, err := relax.GetDate(ctx.PathValues, "from")
+func GetDate(values url.Values, name string) (time.Time, error) {
+	v, err := pathValue(values, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	m := dateValueExp.FindStringSubmatch(v)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("relax: path value %q is not a date: %q", name, v)
+	}
+	year, _ := strconv.Atoi(m[dateValueExp.SubexpIndex("date_year")])
+	mon, mday := 1, 1
+	if s := m[dateValueExp.SubexpIndex("date_mon")]; s != "" {
+		mon, _ = strconv.Atoi(s)
+	}
+	if s := m[dateValueExp.SubexpIndex("date_mday")]; s != "" {
+		mday, _ = strconv.Atoi(s)
+	}
+	t := time.Date(year, time.Month(mon), mday, 0, 0, 0, 0, time.UTC)
+	if t.Day() != mday {
+		return time.Time{}, fmt.Errorf("relax: path value %q is not a calendar date: %q", name, v)
+	}
+	return t, nil
+}
+
+// geoValueExp matches a value of a {geo:varname} PSE, with its components in
+// the groups "geo_lat", "geo_lon", "geo_alt", "geo_crs" and "geo_u".
+var geoValueExp = segmentExp("{geo:geo}")
+
+// GeoPoint is a geo location, as matched by a {geo:varname} PSE. Altitude and
+// Uncertainty are nil if the location doesn't have them. See RFC 5870.
+type GeoPoint struct {
+	Latitude    float64
+	Longitude   float64
+	Altitude    *float64
+	Uncertainty *float64 // in meters; the radius of a circle or sphere.
+	CRS         string   // coordinate reference system; "" is WGS-84.
+}
+
+// GetGeo returns the value of 'name' in the path values, as matched by a
+// {geo:varname} PSE, as a GeoPoint. See GetInt.
+//
+//	loc, err := relax.GetGeo(ctx.PathValues, "location") // "48.2,16.3;u=40"
+//	// loc.Latitude == 48.2, *loc.Uncertainty == 40
+func GetGeo(values url.Values, name string) (GeoPoint, error) {
+	var geo GeoPoint
+	v, err := pathValue(values, name)
+	if err != nil {
+		return geo, err
+	}
+	m := geoValueExp.FindStringSubmatch(v)
+	if m == nil {
+		return geo, fmt.Errorf("relax: path value %q is not a geo location: %q", name, v)
+	}
+	var f [4]*float64
+	for i, group := range []string{"geo_lat", "geo_lon", "geo_alt", "geo_u"} {
+		s := m[geoValueExp.SubexpIndex(group)]
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return geo, fmt.Errorf("relax: path value %q: %w", name, err)
+		}
+		f[i] = &n
+	}
+	geo.Latitude, geo.Longitude, geo.Altitude, geo.Uncertainty = *f[0], *f[1], f[2], f[3]
+	geo.CRS = m[geoValueExp.SubexpIndex("geo_crs")]
+	return geo, nil
 }
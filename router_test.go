@@ -6,8 +6,23 @@
 package relax
 
 import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var testRouter = newRouter()
@@ -50,3 +65,3708 @@ func TestFindHandler(t *testing.T) {
 		}
 	}
 }
+
+var testSegments = []struct {
+	Route string
+	Path  string
+	Name  string
+	Value string
+	Must  bool
+}{
+	{"/days/{datestrict:day}", "/days/2023-01-31", "day", "2023-01-31", true},
+	{"/days/{datestrict:day}", "/days/2023-04-30", "day", "2023-04-30", true},
+	{"/days/{datestrict:day}", "/days/2023-02-28", "day", "2023-02-28", true},
+	{"/days/{datestrict:day}", "/days/2024-02-29", "day", "2024-02-29", true},
+	{"/days/{datestrict:day}", "/days/2000-02-29", "day", "2000-02-29", true},
+	{"/days/{datestrict:day}", "/days/2023-02-30", "", "", false},
+	{"/days/{datestrict:day}", "/days/2024-02-30", "", "", false},
+	{"/days/{datestrict:day}", "/days/2023-04-31", "", "", false},
+	{"/days/{datestrict:day}", "/days/2023-11-31", "", "", false},
+	{"/days/{datestrict:day}", "/days/2023-02-29", "", "", false},
+	{"/days/{datestrict:day}", "/days/1900-02-29", "", "", false},
+	{"/days/{datestrict:day}", "/days/2023-13-01", "", "", false},
+	{"/days/{datestrict:day}", "/days/2023-00-10", "", "", false},
+	{"/days/{datestrict:day}", "/days/2023-01-00", "", "", false},
+	{"/days/{datestrict:day}", "/days/20230131", "", "", false},
+	{"/features/{word:name}/{bool:enabled}", "/features/beta/true", "enabled", "true", true},
+	{"/features/{word:name}/{bool:enabled}", "/features/beta/OFF", "enabled", "OFF", true},
+	{"/features/{word:name}/{bool:enabled}", "/features/beta/Yes", "enabled", "Yes", true},
+	{"/features/{word:name}/{bool:enabled}", "/features/beta/0", "enabled", "0", true},
+	{"/features/{word:name}/{bool:enabled}", "/features/beta/truething", "", "", false},
+	{"/features/{word:name}/{bool:enabled}", "/features/beta/2", "", "", false},
+	{"/users/{email:addr}/profile", "/users/user.name+tag@example.co.uk/profile", "addr", "user.name+tag@example.co.uk", true},
+	{"/users/{email:addr}/profile", "/users/jdoe@example.com/profile", "addr", "jdoe@example.com", true},
+	{"/users/{email:addr}/profile", "/users/user@/profile", "", "", false},
+	{"/users/{email:addr}/profile", "/users/@example.com/profile", "", "", false},
+	{"/users/{email:addr}/profile", "/users/user@example/com/profile", "", "", false},
+	{"/acl/{ipv4:addr}", "/acl/192.168.0.1", "addr", "192.168.0.1", true},
+	{"/acl/{ipv4:addr}", "/acl/255.255.255.0", "addr", "255.255.255.0", true},
+	{"/acl/{ipv4:addr}", "/acl/256.1.1.1", "", "", false},
+	{"/acl/{ipv4:addr}", "/acl/10.0.0", "", "", false},
+	{"/acl/{ipv4:addr}", "/acl/10.0.0.1x", "", "", false},
+	{"/acl6/{ipv6:addr}", "/acl6/::1", "addr", "::1", true},
+	{"/acl6/{ipv6:addr}", "/acl6/2001:db8::1", "addr", "2001:db8::1", true},
+	{"/acl6/{ipv6:addr}", "/acl6/::ffff:192.0.2.1", "addr", "::ffff:192.0.2.1", true},
+	{"/acl6/{ipv6:addr}", "/acl6/2001:db8:0:0:0:0:2:1", "addr", "2001:db8:0:0:0:0:2:1", true},
+	{"/acl6/{ipv6:addr}", "/acl6/fe80::1%eth0", "addr", "fe80::1%eth0", true},
+	{"/acl6/{ipv6:addr}", "/acl6/2001:db8::1zz", "", "", false},
+	{"/acl6/{ipv6:addr}", "/acl6/2001:db8:::1", "", "", false},
+	{"/firewall/{ipcidr:block}/rules", "/firewall/10.0.0.0%2F8/rules", "block", "10.0.0.0/8", true},
+	{"/firewall/{ipcidr:block}/rules", "/firewall/2001:db8::%2f32/rules", "block", "2001:db8::/32", true},
+	{"/firewall/{ipcidr:block}/rules", "/firewall/10.0.0.0%2F40/rules", "", "", false},
+	{"/firewall/{ipcidr:block}/rules", "/firewall/2001:db8::%2F129/rules", "", "", false},
+	{"/firewall/{ipcidr:block}/rules", "/firewall/10.0.0.0/rules", "", "", false},
+	{"/firewall/{ipcidr:block}/rules", "/firewall/10.0.0.0/8/rules", "", "", false},
+	{"/devices/{mac:hwaddr}/status", "/devices/01:23:45:67:89:ab/status", "hwaddr", "01:23:45:67:89:ab", true},
+	{"/devices/{mac:hwaddr}/status", "/devices/01-23-45-67-89-AB/status", "hwaddr", "01-23-45-67-89-AB", true},
+	{"/devices/{mac:hwaddr}/status", "/devices/0123.4567.89ab/status", "hwaddr", "0123.4567.89ab", true},
+	{"/devices/{mac:hwaddr}/status", "/devices/01:23:45:67:89/status", "", "", false},
+	{"/devices/{mac:hwaddr}/status", "/devices/01:23:45:67:89:ab:cd/status", "", "", false},
+	{"/devices/{mac:hwaddr}/status", "/devices/01:23-45:67-89:ab/status", "", "", false},
+	{"/feed/{base64url:cursor}", "/feed/eyJpZCI6MTIzfQ", "cursor", "eyJpZCI6MTIzfQ", true},
+	{"/feed/{base64url:cursor}", "/feed/eyJpZCI6MTIzfQ==", "cursor", "eyJpZCI6MTIzfQ==", true},
+	{"/feed/{base64url:cursor}", "/feed/ab_-", "cursor", "ab_-", true},
+	{"/feed/{base64url:cursor}", "/feed/abc+", "", "", false},
+	{"/feed/{base64url:cursor}", "/feed/abcde", "", "", false},
+	{"/feed/{base64url:cursor}", "/feed/abc?", "", "", false},
+	{"/blobs/{base64:data}", "/blobs/SGVsbG8sIHdvcmxk", "data", "SGVsbG8sIHdvcmxk", true},
+	{"/blobs/{base64:data}", "/blobs/SGk+Pw==", "data", "SGk+Pw==", true},
+	{"/blobs/{base64:data}", "/blobs/SGk=Pw==", "", "", false},
+	{"/blobs/{base64:data}", "/blobs/ab_-", "", "", false},
+	{"/packages/{word:name}/{semver:version}", "/packages/relax/1.2.3", "version", "1.2.3", true},
+	{"/packages/{word:name}/{semver:version}", "/packages/relax/1.0.0-alpha.1", "version", "1.0.0-alpha.1", true},
+	{"/packages/{word:name}/{semver:version}", "/packages/relax/1.0.0+build.5", "version", "1.0.0+build.5", true},
+	{"/packages/{word:name}/{semver:version}", "/packages/relax/1.0.0-rc.1+build.5", "version", "1.0.0-rc.1+build.5", true},
+	{"/packages/{word:name}/{semver:version}", "/packages/relax/1.2", "", "", false},
+	{"/packages/{word:name}/{semver:version}", "/packages/relax/v1.2.3", "", "", false},
+	{"/packages/{word:name}/{semver:version}", "/packages/relax/01.2.3", "", "", false},
+	{"/docs/{objectid:id}", "/docs/507f1f77bcf86cd799439011", "id", "507f1f77bcf86cd799439011", true},
+	{"/docs/{objectid:id}", "/docs/507F1F77BCF86CD799439011", "id", "507F1F77BCF86CD799439011", true},
+	{"/docs/{objectid:id}", "/docs/507f1f77bcf86cd79943901", "", "", false},
+	{"/docs/{objectid:id}", "/docs/507f1f77bcf86cd7994390111", "", "", false},
+	{"/docs/{objectid:id}", "/docs/0x507f1f77bcf86cd7994390", "", "", false},
+	{"/tags/{word:name}", "/tags/foo_bar", "name", "foo_bar", true},
+	{"/tags/{alpha:name}", "/tags/fooBar", "name", "fooBar", true},
+	{"/tags/{alpha:name}", "/tags/foo_bar", "", "", false},
+	{"/tags/{alpha:name}", "/tags/foo1", "", "", false},
+	{"/tags/{alphanum:name}", "/tags/foo1", "name", "foo1", true},
+	{"/tags/{alphanum:name}", "/tags/foo_bar", "", "", false},
+	{"/reports/{enum:period:daily|weekly|monthly}", "/reports/weekly", "period", "weekly", true},
+	{"/reports/{enum:period:daily|weekly|monthly}", "/reports/yearly", "", "", false},
+	{"/reports/{enum:period:daily|weekly|monthly}", "/reports/dailyweekly", "", "", false},
+	{"/files/{enum:format:v1.json|v1.xml}", "/files/v1.json", "format", "v1.json", true},
+	{"/files/{enum:format:v1.json|v1.xml}", "/files/v1xjson", "", "", false},
+	{"/hosts/{word:h}/{port:p}", "/hosts/local/80", "p", "80", true},
+	{"/hosts/{word:h}/{port:p}", "/hosts/local/443", "p", "443", true},
+	{"/hosts/{word:h}/{port:p}", "/hosts/local/65535", "p", "65535", true},
+	{"/hosts/{word:h}/{port:p}", "/hosts/local/0", "p", "0", true},
+	{"/hosts/{word:h}/{port:p}", "/hosts/local/65536", "", "", false},
+	{"/hosts/{word:h}/{port:p}", "/hosts/local/99999", "", "", false},
+	{"/hosts/{word:h}/{port:p}", "/hosts/local/080", "", "", false},
+	{"/orders/{uuid:id}", "/orders/c232ab00-9414-11ec-b3c8-9f6bdeced846", "id", "c232ab00-9414-11ec-b3c8-9f6bdeced846", true},
+	{"/orders/{uuid:id}", "/orders/919108f7-52d1-4320-9bac-f847db4148a8", "id", "919108f7-52d1-4320-9bac-f847db4148a8", true},
+	{"/orders/{uuid:id:4}", "/orders/919108f7-52d1-4320-9bac-f847db4148a8", "id", "919108f7-52d1-4320-9bac-f847db4148a8", true},
+	{"/orders/{uuid:id:4}", "/orders/919108F752D14320BBACF847DB4148A8", "id", "919108F752D14320BBACF847DB4148A8", true},
+	{"/orders/{uuid:id:4}", "/orders/c232ab00-9414-11ec-b3c8-9f6bdeced846", "", "", false},
+	{"/orders/{uuid:id:4}", "/orders/919108f7-52d1-4320-7bac-f847db4148a8", "", "", false},
+	{"/orders/{uuid:id:1}", "/orders/c232ab00-9414-11ec-b3c8-9f6bdeced846", "id", "c232ab00-9414-11ec-b3c8-9f6bdeced846", true},
+	{"/blobs/{hex:n}", "/blobs/0xdeadbeef", "n", "0xdeadbeef", true},
+	{"/blobs/{hex:n}", "/blobs/DEADBEEF", "n", "DEADBEEF", true},
+	{"/blobs/{hex:n}", "/blobs/0x", "", "", false},
+	{"/blobs/{hex:n}", "/blobs/x1", "", "", false},
+	{"/blobs/{hex:n}", "/blobs/0x0x1", "", "", false},
+	{"/blobs/{hex:token(32)}", "/blobs/0123456789abcdef0123456789ABCDEF", "token", "0123456789abcdef0123456789ABCDEF", true},
+	{"/blobs/{hex:token(32)}", "/blobs/0x0123456789abcdef0123456789ABCDEF", "token", "0x0123456789abcdef0123456789ABCDEF", true},
+	{"/blobs/{hex:token(32)}", "/blobs/0123456789abcdef0123456789ABCDE", "", "", false},
+	{"/blobs/{hex:token(32)}", "/blobs/0x0123456789abcdef0123456789ABCDE", "", "", false},
+	{"/blobs/{hex:token(32)}", "/blobs/0123456789abcdef0123456789ABCDEF0", "", "", false},
+	{"/blobs/{hex:token(32)}", "/blobs/0123456789abcdef0123456789ABCDEG", "", "", false},
+	{"/blobs/{hex:key(4,8)}", "/blobs/0xdeadbeef", "key", "0xdeadbeef", true},
+	{"/blobs/{hex:key(4,8)}", "/blobs/0xdeadbeef0", "", "", false},
+	{"/themes/{colorhex:bg}", "/themes/fff", "bg", "fff", true},
+	{"/themes/{colorhex:bg}", "/themes/#ffffff", "bg", "#ffffff", true},
+	{"/themes/{colorhex:bg}", "/themes/ff00ff80", "bg", "ff00ff80", true},
+	{"/themes/{colorhex:bg}", "/themes/gg", "", "", false},
+	{"/themes/{colorhex:bg}", "/themes/fffff", "", "", false},
+	{"/themes/{colorhex:bg}", "/themes/##fff", "", "", false},
+	{"/jobs/{duration:every}/runs", "/jobs/P1Y/runs", "every", "P1Y", true},
+	{"/jobs/{duration:every}/runs", "/jobs/PT30M/runs", "every", "PT30M", true},
+	{"/jobs/{duration:every}/runs", "/jobs/P1DT2H3M4S/runs", "every", "P1DT2H3M4S", true},
+	{"/jobs/{duration:every}/runs", "/jobs/PT1H30M/runs", "every", "PT1H30M", true},
+	{"/jobs/{duration:every}/runs", "/jobs/P/runs", "", "", false},
+	{"/jobs/{duration:every}/runs", "/jobs/PT/runs", "", "", false},
+	{"/jobs/{duration:every}/runs", "/jobs/P1DT/runs", "", "", false},
+	{"/jobs/{duration:every}/runs", "/jobs/1H/runs", "", "", false},
+	{"/schedules/{time:at}", "/schedules/00:00", "at", "00:00", true},
+	{"/schedules/{time:at}", "/schedules/09:30", "at", "09:30", true},
+	{"/schedules/{time:at}", "/schedules/23:59:59", "at", "23:59:59", true},
+	{"/schedules/{time:at}", "/schedules/24:00", "", "", false},
+	{"/schedules/{time:at}", "/schedules/9:30", "", "", false},
+	{"/schedules/{time:at}", "/schedules/12:60", "", "", false},
+	{"/metrics/{timestamp:from}/to/{timestamp:to}", "/metrics/1700000000/to/1700003600", "to", "1700003600", true},
+	{"/metrics/{timestamp:from}/to/{timestamp:to}", "/metrics/0/to/1", "from", "0", true},
+	{"/metrics/{timestamp:from}/to/{timestamp:to}", "/metrics/yesterday/to/1700003600", "", "", false},
+	{"/metrics/{timestamp:from}/to/{timestamp:to}", "/metrics/170000000000/to/1", "", "", false},
+	{"/contacts/{phone:number}", "/contacts/+14155552671", "number", "+14155552671", true},
+	{"/contacts/{phone:number}", "/contacts/4155552671", "", "", false},
+	{"/contacts/{phone:number}", "/contacts/+0123", "", "", false},
+	{"/contacts/{phone:number}", "/contacts/+1234567890123456", "", "", false},
+	{"/nodes/{hostname:fqdn}/health", "/nodes/api.example.com/health", "fqdn", "api.example.com", true},
+	{"/nodes/{hostname:fqdn}/health", "/nodes/localhost/health", "fqdn", "localhost", true},
+	{"/nodes/{hostname:fqdn}/health", "/nodes/x-1.example.com/health", "fqdn", "x-1.example.com", true},
+	{"/nodes/{hostname:fqdn}/health", "/nodes/-bad.example.com/health", "", "", false},
+	{"/nodes/{hostname:fqdn}/health", "/nodes/bad-.example.com/health", "", "", false},
+	{"/nodes/{hostname:fqdn}/health", "/nodes/exa_mple.com/health", "", "", false},
+	{"/nodes/{hostname:fqdn}/health", "/nodes/example..com/health", "", "", false},
+	{"/pages/{int:n(1,100)}", "/pages/1", "n", "1", true},
+	{"/pages/{int:n(1,100)}", "/pages/100", "n", "100", true},
+	{"/pages/{int:n(1,100)}", "/pages/42", "n", "42", true},
+	{"/pages/{int:n(1,100)}", "/pages/0", "", "", false},
+	{"/pages/{int:n(1,100)}", "/pages/101", "", "", false},
+	{"/pages/{int:n(1,100)}", "/pages/-1", "", "", false},
+	{"/temps/{int:t(-40,85)}", "/temps/-40", "t", "-40", true},
+	{"/temps/{int:t(-40,85)}", "/temps/85", "t", "85", true},
+	{"/temps/{int:t(-40,85)}", "/temps/-41", "", "", false},
+	{"/temps/{int:t(-40,85)}", "/temps/86", "", "", false},
+	{"/items/{uint:n(10,250)}", "/items/10", "n", "10", true},
+	{"/items/{uint:n(10,250)}", "/items/199", "n", "199", true},
+	{"/items/{uint:n(10,250)}", "/items/250", "n", "250", true},
+	{"/price/{float:amount}", "/price/100", "amount", "100", true},
+	{"/price/{float:amount}", "/price/100.5", "amount", "100.5", true},
+	{"/price/{float:amount}", "/price/-3", "amount", "-3", true},
+	{"/price/{float:amount}", "/price/+0.25", "amount", "+0.25", true},
+	{"/price/{float:amount}", "/price/1e3", "", "", false},
+	{"/price/{float:amount}", "/price/100.", "", "", false},
+	{"/price/{float:amount}", "/price/.5", "", "", false},
+	{"/measures/{sci:value}", "/measures/1e10", "value", "1e10", true},
+	{"/measures/{sci:value}", "/measures/-2.5E-3", "value", "-2.5E-3", true},
+	{"/measures/{sci:value}", "/measures/3.14", "value", "3.14", true},
+	{"/measures/{sci:value}", "/measures/+6.02e+23", "value", "+6.02e+23", true},
+	{"/measures/{sci:value}", "/measures/42", "value", "42", true},
+	{"/measures/{sci:value}", "/measures/e5", "", "", false},
+	{"/measures/{sci:value}", "/measures/1e", "", "", false},
+	{"/measures/{sci:value}", "/measures/1.e5", "", "", false},
+	{"/ids/{uint:id}", "/ids/12345678901234567890", "id", "12345678901234567890", true},
+	{"/ids/{uint:id}", "/ids/123456789012345678901234567890", "id", "123456789012345678901234567890", true},
+	{"/ids/{uint:id}", "/ids/-1", "", "", false},
+	{"/items/{uint:n(10,250)}", "/items/9", "", "", false},
+	{"/items/{uint:n(10,250)}", "/items/251", "", "", false},
+	{"/products/{word:code(3,6)}", "/products/abc", "code", "abc", true},
+	{"/products/{word:code(3,6)}", "/products/abc_12", "code", "abc_12", true},
+	{"/products/{word:code(3,6)}", "/products/ab", "", "", false},
+	{"/products/{word:code(3,6)}", "/products/abcdefg", "", "", false},
+	{"/products/{word:code(3)}", "/products/abc", "code", "abc", true},
+	{"/products/{word:code(3)}", "/products/abcd", "", "", false},
+	{"/products/{word:code(3,)}", "/products/abcdefghij", "code", "abcdefghij", true},
+	{"/products/{word:code(3,)}", "/products/ab", "", "", false},
+	{"/answers/{rei:yes}", "/answers/YES", "_1", "YES", true},
+	{"/answers/{rei:yes}", "/answers/yes", "_1", "yes", true},
+	{"/answers/{rei:yes}", "/answers/no", "", "", false},
+	{"/formats/{rei:(?P<format>json|xml)}", "/formats/JSON", "format", "JSON", true},
+	{"/urns/{rei:urn:isbn:\\d+}", "/urns/URN:ISBN:123", "_1", "URN:ISBN:123", true},
+	{"/formats/{re:(?P<format>json|xml)}", "/formats/JSON", "", "", false},
+	{"/slugs/{uword:name}", "/slugs/café", "name", "café", true},
+	{"/slugs/{uword:name}", "/slugs/naïve", "name", "naïve", true},
+	{"/slugs/{uword:name}", "/slugs/東京", "name", "東京", true},
+	{"/slugs/{uword:name}", "/slugs/snake_case2", "name", "snake_case2", true},
+	{"/slugs/{uword:name}", "/slugs/a-b", "", "", false},
+	{"/slugs/{word:name}", "/slugs/café", "", "", false},
+	{"/slugs/{word:name}", "/slugs/naïve", "", "", false},
+	{"/slugs/{word:name}", "/slugs/東京", "", "", false},
+	{"/assets/{token:filename}", "/assets/report.v2-final", "filename", "report.v2-final", true},
+	{"/assets/{token:filename}", "/assets/app.min.js", "filename", "app.min.js", true},
+	{"/assets/{token:filename}", "/assets/css/app.css", "", "", false},
+	{"/assets/{token:filename}", "/assets/a~b", "", "", false},
+}
+
+func TestSegmentExp(t *testing.T) {
+	for _, tt := range testSegments {
+		router := newRouter()
+		router.AddRoute("GET", tt.Route, testHandler)
+		var v url.Values
+		_, err := router.FindHandler("GET", tt.Path, &v)
+		if !tt.Must {
+			if err != ErrRouteNotFound {
+				t.Errorf("%s: expected %q not to match, got %v", tt.Route, tt.Path, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected %q to match: %s", tt.Route, tt.Path, err.Error())
+			continue
+		}
+		if tt.Name != "" && v.Get(tt.Name) != tt.Value {
+			t.Errorf("%s: expected %s=%q, got %q", tt.Route, tt.Name, tt.Value, v.Get(tt.Name))
+		}
+	}
+}
+
+func TestPathTail(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/files/{path:rest}", testHandler)
+	router.AddRoute("GET", "/files/{word:name}/meta", testHandler)
+
+	for path, rest := range map[string]string{
+		"/files/a/b/c.txt": "a/b/c.txt",
+		"/files/a.txt":     "a.txt",
+		"/files/a/b":       "a/b",
+	} {
+		var v url.Values
+		if _, err := router.FindHandler("GET", path, &v); err != nil {
+			t.Errorf("expected %q to match: %s", path, err.Error())
+			continue
+		}
+		if v.Get("rest") != rest {
+			t.Errorf("%s: expected rest=%q, got %q", path, rest, v.Get("rest"))
+		}
+		if v.Get("name") != "" {
+			t.Errorf("%s: expected no name value, got %q", path, v.Get("name"))
+		}
+	}
+
+	var v url.Values
+	if _, err := router.FindHandler("GET", "/files/a/meta", &v); err != nil || v.Get("name") != "a" {
+		t.Errorf("expected /files/a/meta to match name=a: %v %v", err, v)
+	}
+	if _, err := router.FindHandler("GET", "/files", nil); err != ErrRouteNotFound {
+		t.Errorf("expected /files not to match, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected {path:varname} in a middle segment to panic")
+		}
+	}()
+	router.AddRoute("GET", "/files/{path:rest}/meta", testHandler)
+}
+
+func TestSegmentWordTypes(t *testing.T) {
+	var alpha, alphanum, word bool
+	router := newRouter()
+	router.AddRoute("GET", "/tags/{alpha:name}", func(ctx *Context) { alpha = true })
+	router.AddRoute("GET", "/tags/{alphanum:name}", func(ctx *Context) { alphanum = true })
+	router.AddRoute("GET", "/tags/{word:name}", func(ctx *Context) { word = true })
+
+	for _, path := range []string{"/tags/foo", "/tags/foo1", "/tags/foo_bar"} {
+		h, err := router.FindHandler("GET", path, nil)
+		if err != nil {
+			t.Errorf("expected %q to match: %s", path, err.Error())
+			continue
+		}
+		h(nil)
+	}
+	if !alpha || !alphanum || !word {
+		t.Errorf("expected each type to be routed: alpha=%v alphanum=%v word=%v", alpha, alphanum, word)
+	}
+}
+
+func TestSegmentEnumEmpty(t *testing.T) {
+	for _, pattern := range []string{"{enum:period:}", "{enum:period:daily||monthly}"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %q to panic", pattern)
+				}
+			}()
+			segmentExp(pattern)
+		}()
+	}
+}
+
+func TestRangeExp(t *testing.T) {
+	ranges := [][2]int64{{0, 0}, {1, 100}, {7, 1234}, {-250, -3}, {-99, 99}, {190, 19999}}
+	for _, r := range ranges {
+		rx := regexp.MustCompile(`^` + rangeExp(r[0], r[1]) + `$`)
+		for n := r[0] - 20; n <= r[1]+20; n++ {
+			s := strconv.FormatInt(n, 10)
+			if rx.MatchString(s) != (n >= r[0] && n <= r[1]) {
+				t.Errorf("range (%d,%d): unexpected match result for %s", r[0], r[1], s)
+			}
+		}
+	}
+}
+
+func TestSegmentLengthSpec(t *testing.T) {
+	for _, pattern := range []string{"{word:code()}", "{word:code(a)}", "{word:code(6,3)}", "{word:code(1,2,3)}", "{word:code(0)}"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %q to panic", pattern)
+				}
+			}()
+			newRouter().AddRoute("GET", "/products/"+pattern, testHandler)
+		}()
+	}
+}
+
+func TestSegmentRangeSpec(t *testing.T) {
+	for _, pattern := range []string{"{uint:n(1,)}", "{int:n(5)}", "{int:n()}", "{uint:n(a,b)}", "{int:n(1,2,3)}", "{uint:n(-1,5)}", "{int:n(9,1)}"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %q to panic", pattern)
+				}
+			}()
+			newRouter().AddRoute("GET", "/products/"+pattern, testHandler)
+		}()
+	}
+}
+
+func TestRangeExpMinInt64(t *testing.T) {
+	rx := regexp.MustCompile(`^` + rangeExp(math.MinInt64, -1) + `$`)
+	for _, s := range []string{"-1", "-9223372036854775808", "-9223372036854775807"} {
+		if !rx.MatchString(s) {
+			t.Errorf("expected %s to match", s)
+		}
+	}
+	for _, s := range []string{"0", "-9223372036854775809"} {
+		if rx.MatchString(s) {
+			t.Errorf("expected %s not to match", s)
+		}
+	}
+}
+
+func TestOptionalSegment(t *testing.T) {
+	var reached int
+	router := newRouter()
+	router.AddRoute("GET", "/users/{uint:id}/{word:section?}", func(ctx *Context) { reached++ })
+
+	for path, section := range map[string]string{
+		"/users/5":         "",
+		"/users/5/profile": "profile",
+	} {
+		var v url.Values
+		h, err := router.FindHandler("GET", path, &v)
+		if err != nil {
+			t.Errorf("expected %q to match: %s", path, err.Error())
+			continue
+		}
+		h(nil)
+		if v.Get("id") != "5" {
+			t.Errorf("%s: expected id=5, got %q", path, v.Get("id"))
+		}
+		if _, ok := v["section"]; ok != (section != "") || v.Get("section") != section {
+			t.Errorf("%s: expected section=%q, got %v", path, section, v["section"])
+		}
+	}
+	if reached != 2 {
+		t.Errorf("expected both paths to reach the handler, got %d", reached)
+	}
+	if _, err := router.FindHandler("GET", "/users/5/profile/extra", nil); err != ErrRouteNotFound {
+		t.Errorf("expected extra segments not to match, got %v", err)
+	}
+}
+
+func TestOptionalSegmentDefault(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/reports/{uint:id}/{word:format=json}", testHandler)
+
+	for path, format := range map[string]string{
+		"/reports/5":     "json",
+		"/reports/5/xml": "xml",
+	} {
+		var v url.Values
+		if _, err := router.FindHandler("GET", path, &v); err != nil {
+			t.Errorf("expected %q to match: %s", path, err.Error())
+			continue
+		}
+		if len(v["format"]) != 1 || v.Get("format") != format {
+			t.Errorf("%s: expected format=%q, got %v", path, format, v["format"])
+		}
+	}
+}
+
+func TestOptionalSegmentConflict(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/g", testHandler)
+	if err := router.AddRouteErr("GET", "/g/{word:x?}", testHandler); err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Errorf("expected a conflict error, got %v", err)
+	}
+	if router.HasRoute("GET", "/g/{word:x?}") {
+		t.Error("expected the optional route not to be added")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected AddRoute to panic")
+			}
+		}()
+		router.AddRoute("GET", "/g/{uint:x=1}", testHandler)
+	}()
+
+	// re-adding the optional route, with or without a trailing slash, is fine.
+	router.AddRoute("GET", "/a/{word:x?}", testHandler)
+	if err := router.AddRouteErr("GET", "/a/{word:x?}/", testHandler); err != nil {
+		t.Errorf("expected the route to be replaced: %s", err.Error())
+	}
+	var patterns []string
+	for _, r := range router.ListRoutes() {
+		patterns = append(patterns, r.Pattern)
+	}
+	if got := strings.Join(patterns, " "); got != "/g /a/{word:x?}" {
+		t.Errorf("unexpected routes: %s", got)
+	}
+}
+
+func TestRedirectNotFoundHandler(t *testing.T) {
+	router := newRouter()
+	router.RedirectTrailingSlash = true
+	router.RedirectCleanPath = true
+	router.AddRoute("GET", "/users", testHandler)
+	router.SetNotFoundHandler(testHandler)
+	router.SetMethodNotAllowedHandler(testHandler)
+
+	for _, test := range []struct{ Method, Path, Location string }{
+		{"GET", "/nope/", ""},
+		{"GET", "/nope//x", ""},
+		{"POST", "/users/", ""},
+		{"OPTIONS", "/users/", ""},
+		{"GET", "/users/", "/users"},
+		{"HEAD", "//users", "/users"},
+	} {
+		h, err := router.FindHandler(test.Method, test.Path, nil)
+		if test.Location == "" {
+			if err != nil || h == nil {
+				t.Errorf("%s %s: expected a handler without redirect, got %v", test.Method, test.Path, err)
+			}
+			continue
+		}
+		if e, ok := err.(*StatusError); !ok || e.Details != test.Location {
+			t.Errorf("%s %s: expected a redirect to %s, got %v", test.Method, test.Path, test.Location, err)
+		}
+	}
+}
+
+func TestRedirectTrailingSlash(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/users", testHandler)
+	router.AddRoute("POST", "/users", testHandler)
+
+	if _, err := router.FindHandler("GET", "/users/", nil); err != nil {
+		t.Errorf("expected lenient mode to match: %s", err.Error())
+	}
+
+	router.RedirectTrailingSlash = true
+	for method, code := range map[string]int{"GET": 301, "HEAD": 301, "POST": 308} {
+		_, err := router.FindHandler(method, "/users/", nil)
+		e, ok := err.(*StatusError)
+		if !ok || e.Code != code || e.Details != "/users" {
+			t.Errorf("%s: expected redirect %d to /users, got %v", method, code, err)
+		}
+	}
+	if _, err := router.FindHandler("GET", "/users", nil); err != nil {
+		t.Errorf("expected canonical path to match: %s", err.Error())
+	}
+	if _, err := router.FindHandler("GET", "/nothing/", nil); err != ErrRouteNotFound {
+		t.Errorf("expected unknown path not to redirect, got %v", err)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	router := newRouter()
+	router.CaseInsensitive = true
+	router.AddRoute("GET", "/API/Users/{word:name}/{enum:format:json|xml}", testHandler)
+	router.AddRoute("GET", "/api/items/{uint:id}", testHandler)
+
+	for path, name := range map[string]string{
+		"/api/users/Bob/json": "Bob",
+		"/API/USERS/bob/XML":  "bob",
+		"/Api/Users/BOB/Json": "BOB",
+	} {
+		var v url.Values
+		if _, err := router.FindHandler("GET", path, &v); err != nil {
+			t.Errorf("expected %q to match: %s", path, err.Error())
+			continue
+		}
+		if v.Get("name") != name {
+			t.Errorf("%s: expected name=%q, got %q", path, name, v.Get("name"))
+		}
+	}
+	if _, err := router.FindHandler("GET", "/API/ITEMS/5", nil); err != nil {
+		t.Errorf("expected /API/ITEMS/5 to match: %s", err.Error())
+	}
+
+	strict := newRouter()
+	strict.AddRoute("GET", "/api/users/{enum:format:json|xml}", testHandler)
+	for _, path := range []string{"/API/users/json", "/api/users/JSON"} {
+		if _, err := strict.FindHandler("GET", path, nil); err != ErrRouteNotFound {
+			t.Errorf("expected %q not to match by default, got %v", path, err)
+		}
+	}
+}
+
+func TestStaticLinks(t *testing.T) {
+	router := newRouter()
+	for i := 0; i < 1000; i++ {
+		router.AddRoute("GET", "/items/e"+strconv.Itoa(i), testHandler)
+	}
+	router.CaseInsensitive = true
+	router.AddRoute("GET", "/items/Fold", testHandler)
+	router.AddRoute("GET", "/items/{uint:id}", testHandler)
+
+	for _, path := range []string{"/items/e0", "/items/e999", "/items/FOLD", "/items/fold", "/items/5"} {
+		if _, err := router.FindHandler("GET", path, nil); err != nil {
+			t.Errorf("expected %q to match: %s", path, err.Error())
+		}
+	}
+	if _, err := router.FindHandler("GET", "/items/E1", nil); err != ErrRouteNotFound {
+		t.Errorf("expected /items/E1 not to match, got %v", err)
+	}
+	if !router.HasRoute("GET", "/items/{uint:id}") {
+		t.Error("expected the PSE route to be found by its pattern")
+	}
+
+	clone := router.Clone()
+	router.DeleteRoute("GET", "/items/e500")
+	if _, err := router.FindHandler("GET", "/items/e500", nil); err != ErrRouteNotFound {
+		t.Errorf("expected deleted route not to match, got %v", err)
+	}
+	if _, err := clone.FindHandler("GET", "/items/e500", nil); err != nil {
+		t.Errorf("expected clone to keep the route: %s", err.Error())
+	}
+	if _, err := router.Freeze().FindHandler("GET", "/items/fOlD", nil); err != nil {
+		t.Errorf("expected frozen router to match case-insensitive: %s", err.Error())
+	}
+}
+
+func TestStaticRoutes(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/", testHandler)
+	router.AddRoute("GET", "/api/users", testHandler)
+	router.AddRoute("GET", "/api/users/{word:name}", testHandler)
+	router.AddRoute("GET", "/api/users/me", testHandler)
+	router.AddRoute("GET", "/api/{word:kind}/list", testHandler)
+	router.AddRoute("GET", "/api/tabs/{word:tab?}", testHandler)
+	router.AddRoute("GET", "//acme.example.com/api/users", testHandler)
+	router.CaseInsensitive = true
+	router.AddRoute("GET", "/Admin/Users", testHandler)
+
+	for path, route := range map[string]string{
+		"/":                             "/",
+		"/api/users/":                   "/api/users",
+		"/api/users/me":                 "/api/users/me",
+		"/api/users/bob":                "/api/users/{word:name}",
+		"/Admin/Users":                  "/Admin/Users",
+		"/admin/USERS":                  "/Admin/Users",
+		"/api/users/m%65":               "/api/users/me",
+		"/api/posts/list":               "/api/{word:kind}/list",
+		"/api/tabs":                     "/api/tabs/{word:tab?}",
+		"//acme.example.com/api/users":  "//acme.example.com/api/users",
+		"//other.example.com/api/users": "/api/users",
+	} {
+		for _, r := range []Router{router, router.Freeze()} {
+			var v url.Values
+			if _, err := r.FindHandler("GET", path, &v); err != nil {
+				t.Errorf("%s: expected a match: %s", path, err.Error())
+				continue
+			}
+			if v.Get(RoutePattern) != route {
+				t.Errorf("%s: expected route %q, got %q", path, route, v.Get(RoutePattern))
+			}
+		}
+	}
+
+	router.DeleteRoute("GET", "/api/users/me")
+	router.DeleteRoute("GET", "/api/tabs/{word:tab?}")
+	for path, route := range map[string]string{
+		"/api/users/me": "/api/users/{word:name}",
+		"/api/tabs":     "",
+	} {
+		var v url.Values
+		router.FindHandler("GET", path, &v)
+		if v.Get(RoutePattern) != route {
+			t.Errorf("%s: expected route %q after delete, got %q", path, route, v.Get(RoutePattern))
+		}
+	}
+	if _, err := router.Clone().FindHandler("GET", "/api/users", nil); err != nil {
+		t.Errorf("expected a match in clone: %s", err.Error())
+	}
+}
+
+func TestOptionsHandler(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/posts/{uint:id}", testHandler)
+	router.AddRoute("POST", "/posts/{uint:id}", testHandler)
+	router.AddRoute("DELETE", "/posts/{uint:id}", testHandler)
+	router.AddRoute("GET", "/tags", testHandler)
+	router.AddRoute("OPTIONS", "/tags", func(ctx *Context) { ctx.WriteHeader(http.StatusOK) })
+
+	h, err := router.FindHandler("OPTIONS", "/posts/5", nil)
+	if err != nil {
+		t.Fatalf("expected OPTIONS to be handled: %s", err.Error())
+	}
+	w := httptest.NewRecorder()
+	h(&Context{ResponseWriter: w})
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "DELETE, GET, HEAD, OPTIONS, POST" {
+		t.Errorf("expected Allow with GET, POST and DELETE, got %q", allow)
+	}
+
+	h, _ = router.FindHandler("OPTIONS", "/tags", nil)
+	w = httptest.NewRecorder()
+	h(&Context{ResponseWriter: w})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the OPTIONS route to be used, got status %d", w.Code)
+	}
+
+	if _, err := router.FindHandler("OPTIONS", "/nothing", nil); err != ErrRouteNotFound {
+		t.Errorf("expected unknown path not to be handled, got %v", err)
+	}
+}
+
+func TestBadMethodAllow(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/posts/{uint:id}", testHandler)
+
+	_, err := router.FindHandler("POST", "/posts/5", nil)
+	e, ok := err.(*StatusError)
+	if !ok || e.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405, got %v", err)
+	}
+	if e.Details != "GET, HEAD" {
+		t.Errorf("expected Allow to list GET, HEAD, got %v", e.Details)
+	}
+	if !errors.Is(err, ErrRouteBadMethod) || errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("expected the error to be ErrRouteBadMethod, got %v", err)
+	}
+	if _, err := router.FindHandler("GET", "/users", nil); !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("expected the error to be ErrRouteNotFound, got %v", err)
+	}
+
+	svc := NewService("/")
+	svc.Router().AddRoute("GET", "/posts/{uint:id}", testHandler)
+	w := httptest.NewRecorder()
+	svc.ServeHTTP(w, httptest.NewRequest("DELETE", "/posts/5", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("expected Allow to list GET, HEAD, got %q", allow)
+	}
+}
+
+func TestResourceAllowHost(t *testing.T) {
+	svc := NewService("/")
+	res := &Resource{service: svc}
+	svc.Router().AddRoute("GET", "//api.example.com/notes/{name}", testHandler)
+	svc.Router().AddRoute("DELETE", "//api.example.com/notes/{name}", res.MethodNotAllowed)
+	svc.Router().AddRoute("OPTIONS", "//api.example.com/notes/{name}", res.OptionsHandler)
+	for method, code := range map[string]int{"DELETE": http.StatusMethodNotAllowed, "OPTIONS": http.StatusNoContent} {
+		w := httptest.NewRecorder()
+		svc.ServeHTTP(w, httptest.NewRequest(method, "http://api.example.com/notes/a%20b", nil))
+		if w.Code != code {
+			t.Errorf("%s: expected status %d, got %d", method, code, w.Code)
+		}
+		if allow := w.Header().Get("Allow"); allow != "DELETE, GET, HEAD, OPTIONS" {
+			t.Errorf("%s: expected Allow to list the host route methods, got %q", method, allow)
+		}
+	}
+}
+
+func TestStaticPrecedence(t *testing.T) {
+	var routes = []struct {
+		Path   string
+		Static bool
+	}{
+		{"/users/me", true},
+		{"/users/{word:name}", false},
+		{"/users/{name}", false},
+	}
+	orders := [][]int{{0, 1, 2}, {1, 0, 2}, {2, 1, 0}, {1, 2, 0}}
+	for _, order := range orders {
+		var static bool
+		router := newRouter()
+		for _, i := range order {
+			isStatic := routes[i].Static
+			router.AddRoute("GET", routes[i].Path, func(ctx *Context) { static = isStatic })
+		}
+		h, err := router.FindHandler("GET", "/users/me", nil)
+		if err != nil {
+			t.Errorf("order %v: expected /users/me to match: %s", order, err.Error())
+			continue
+		}
+		if h(nil); !static {
+			t.Errorf("order %v: expected /users/me to reach the static route", order)
+		}
+		var v url.Values
+		if _, err := router.FindHandler("GET", "/users/bob", &v); err != nil || v.Get("name") != "bob" {
+			t.Errorf("order %v: expected /users/bob to match a PSE route: %v", order, err)
+		}
+		if catchAll := router.root.links[0].links[0].links; catchAll[len(catchAll)-1].pseg != "{name}" {
+			t.Errorf("order %v: expected the catch-all link to be last", order)
+		}
+	}
+}
+
+func TestPSEPrecedence(t *testing.T) {
+	routes := []string{
+		"/items/{item}",
+		"/items/{word:name}",
+		"/items/{uint:id}",
+		"/items/{uuid:uuid}",
+		"/items/{date:day}",
+		"/items/{enum:kind:new|used}",
+		"/items/{alpha:letters}",
+		"/items/{int:num}",
+		"/items/@{word:handle}",
+	}
+	var tests = []struct {
+		Path, Route string
+	}{
+		{"/items/123", "/items/{uint:id}"},
+		{"/items/-123", "/items/{int:num}"},
+		{"/items/new", "/items/{enum:kind:new|used}"},
+		{"/items/abc", "/items/{alpha:letters}"},
+		{"/items/abc_1", "/items/{word:name}"},
+		{"/items/2024-01-31", "/items/{date:day}"},
+		{"/items/123e4567-e89b-12d3-a456-426614174000", "/items/{uuid:uuid}"},
+		{"/items/@bob", "/items/@{word:handle}"},
+		{"/items/a b", "/items/{item}"},
+	}
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 20; n++ {
+		var route string
+		router := newRouter()
+		for _, i := range r.Perm(len(routes)) {
+			path := routes[i]
+			router.AddRoute("GET", path, func(ctx *Context) { route = path })
+		}
+		for _, test := range tests {
+			h, err := router.FindHandler("GET", test.Path, nil)
+			if err != nil {
+				t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+				continue
+			}
+			if h(nil); route != test.Route {
+				t.Errorf("%s: expected route %s, got %s", test.Path, test.Route, route)
+			}
+		}
+	}
+}
+
+func TestCatchAllLast(t *testing.T) {
+	var route string
+	router := newRouter()
+	for _, path := range []string{"/files/*", "/files/{name}", "/files/{uint:id}", "/files/{date:day}"} {
+		path := path
+		router.AddRoute("GET", path, func(ctx *Context) { route = path })
+	}
+	links := router.root.links[0].links[0].links
+	for i, pseg := range []string{"{date:day}", "{uint:id}", "*", "{name}"} {
+		if links[i].pseg != pseg {
+			t.Errorf("expected link %d to be %q, got %q", i, pseg, links[i].pseg)
+		}
+	}
+	for path, want := range map[string]string{
+		"/files/5":          "/files/{uint:id}",
+		"/files/2024-01-31": "/files/{date:day}",
+		"/files/readme":     "/files/*",
+	} {
+		h, err := router.FindHandler("GET", path, nil)
+		if err != nil {
+			t.Errorf("%s: expected a match: %s", path, err.Error())
+			continue
+		}
+		if h(nil); route != want {
+			t.Errorf("%s: expected route %s, got %s", path, want, route)
+		}
+	}
+}
+
+func TestBacktracking(t *testing.T) {
+	routes := []string{
+		"/a/{uint:id}/x",
+		"/a/{word:name}/y",
+		"/b/c/x",
+		"/b/{word:name}/y",
+		"/d/{uint:id}/{enum:op:start|stop}",
+		"/d/{word:name}/{word:op}/z",
+		"/files/{path:rest}",
+		"/files/{uint:id}/meta",
+	}
+	var tests = []struct {
+		Path, Route string
+		Values      map[string]string
+	}{
+		{"/a/5/x", "/a/{uint:id}/x", map[string]string{"id": "5"}},
+		{"/a/5/y", "/a/{word:name}/y", map[string]string{"name": "5", "id": ""}},
+		{"/b/c/y", "/b/{word:name}/y", map[string]string{"name": "c"}},
+		{"/d/5/start", "/d/{uint:id}/{enum:op:start|stop}", map[string]string{"id": "5", "op": "start"}},
+		{"/d/5/start/z", "/d/{word:name}/{word:op}/z", map[string]string{"name": "5", "op": "start", "id": ""}},
+		{"/files/5/meta", "/files/{uint:id}/meta", map[string]string{"id": "5"}},
+		{"/files/5/other", "/files/{path:rest}", map[string]string{"rest": "5/other", "id": ""}},
+	}
+	for _, combine := range []bool{false, true} {
+		router := newRouter()
+		router.CombineRegexps = combine
+		for _, route := range routes {
+			router.AddRoute("GET", route, testHandler)
+		}
+		for _, r := range []Router{router, router.Freeze()} {
+			for _, test := range tests {
+				var v url.Values
+				if _, err := r.FindHandler("GET", test.Path, &v); err != nil {
+					t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+					continue
+				}
+				if v.Get(RoutePattern) != test.Route {
+					t.Errorf("%s: expected route %s, got %s", test.Path, test.Route, v.Get(RoutePattern))
+				}
+				n := 0
+				for k, value := range test.Values {
+					if v.Get(k) != value {
+						t.Errorf("%s: expected %s=%q, got %q", test.Path, k, value, v.Get(k))
+					}
+					if value != "" {
+						n++
+					}
+				}
+				// the values of the links tried before are not kept.
+				if k := "_" + strconv.Itoa(n+1); v.Get(k) != "" {
+					t.Errorf("%s: unexpected value %s=%q", test.Path, k, v.Get(k))
+				}
+			}
+		}
+		if _, err := router.FindHandler("GET", "/a/5/z", nil); err != ErrRouteNotFound {
+			t.Errorf("expected /a/5/z not to match, got %v", err)
+		}
+	}
+}
+
+func TestTerminalPSE(t *testing.T) {
+	routes := []string{
+		"/t/{uint:id}",
+		"/t/{word:name}/more",
+		"/t/{uint:id}/x/{word:leaf}",
+		"/u/{word:name}/{uint:id}/deep",
+		"/u/{uint:id}",
+		"/u/{word:name}",
+		"/v/{path:rest}",
+		"/v/{uint:id}",
+	}
+	var tests = []struct {
+		Path, Route string
+	}{
+		{"/t/5", "/t/{uint:id}"},
+		{"/t/5/more", "/t/{word:name}/more"},
+		{"/t/5/x/y", "/t/{uint:id}/x/{word:leaf}"},
+		{"/u/5", "/u/{uint:id}"},
+		{"/u/bob", "/u/{word:name}"},
+		{"/u/bob/5/deep", "/u/{word:name}/{uint:id}/deep"},
+		{"/v/5", "/v/{uint:id}"},
+		{"/v/5/6", "/v/{path:rest}"},
+	}
+	for _, combine := range []bool{false, true} {
+		router := newRouter()
+		router.CombineRegexps = combine
+		for _, route := range routes {
+			router.AddRoute("GET", route, testHandler)
+		}
+		// the same routes, under a host and deeper in the path.
+		for _, route := range routes {
+			router.AddRoute("GET", "//{word:sub}.example.com/a/b/c"+route, testHandler)
+		}
+		for _, r := range []Router{router, router.Freeze()} {
+			for _, test := range tests {
+				for _, prefix := range []string{"", "//acme.example.com/a/b/c"} {
+					var v url.Values
+					if _, err := r.FindHandler("GET", prefix+test.Path, &v); err != nil {
+						t.Errorf("%s: expected a match: %s", prefix+test.Path, err.Error())
+						continue
+					}
+					if route := strings.TrimPrefix(v.Get(RoutePattern), "//{word:sub}.example.com/a/b/c"); route != test.Route {
+						t.Errorf("%s: expected route %s, got %s", prefix+test.Path, test.Route, route)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestHostRoutes(t *testing.T) {
+	var route string
+	router := newRouter()
+	for _, path := range []string{
+		"/api/stats",
+		"//admin.example.com/api/stats",
+		"//www.example.com/api/stats",
+		"//*.example.com/api/stats",
+		"//admin.example.com",
+	} {
+		path := path
+		router.AddRoute("GET", path, func(ctx *Context) { route = path })
+	}
+	var tests = []struct {
+		Path, Route, Wild string
+	}{
+		{"//admin.example.com/api/stats", "//admin.example.com/api/stats", ""},
+		{"//ADMIN.Example.com/api/stats", "//admin.example.com/api/stats", ""},
+		{"//www.example.com/api/stats", "//www.example.com/api/stats", ""},
+		{"//shop.example.com/api/stats", "//*.example.com/api/stats", "shop"},
+		{"//a.b.example.com/api/stats", "//*.example.com/api/stats", "a.b"},
+		{"//example.com/api/stats", "/api/stats", ""},
+		{"//other.com/api/stats", "/api/stats", ""},
+		{"/api/stats", "/api/stats", ""},
+		{"//admin.example.com/", "//admin.example.com", ""},
+	}
+	for _, test := range tests {
+		var values url.Values
+		h, err := router.FindHandler("GET", test.Path, &values)
+		if err != nil {
+			t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+			continue
+		}
+		if h(nil); route != test.Route {
+			t.Errorf("%s: expected route %s, got %s", test.Path, test.Route, route)
+		}
+		if values.Get("wild") != test.Wild {
+			t.Errorf("%s: expected wild %q, got %q", test.Path, test.Wild, values.Get("wild"))
+		}
+	}
+	if _, err := router.FindHandler("GET", "//www.example.com/api/users", nil); err != ErrRouteNotFound {
+		t.Errorf("expected ErrRouteNotFound for an unknown path of a host route")
+	}
+	if methods := router.PathMethods("//admin.example.com/"); methods != "GET, HEAD" {
+		t.Errorf("expected host methods, got %q", methods)
+	}
+
+	svc := NewService("/")
+	svc.Router().AddRoute("GET", "//admin.example.com/status", func(ctx *Context) { ctx.WriteHeader(http.StatusAccepted) })
+	r := httptest.NewRequest("GET", "/status", nil)
+	r.Host = "admin.example.com:8080"
+	w := httptest.NewRecorder()
+	if svc.ServeHTTP(w, r); w.Code != http.StatusAccepted {
+		t.Errorf("expected host route to be served, got %d", w.Code)
+	}
+	r.Host = "www.example.com"
+	w = httptest.NewRecorder()
+	if svc.ServeHTTP(w, r); w.Code != http.StatusNotFound {
+		t.Errorf("expected other hosts not to match, got %d", w.Code)
+	}
+}
+
+func TestHostSegments(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "//{word:tenant}.example.com/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "//api-{uint:region}.{enum:env:dev|prod}.example.com/api/users/{uint:id}", testHandler)
+	var tests = []struct {
+		Host  string
+		Must  bool
+		Names []string
+		Value []string
+	}{
+		{"acme.example.com", true, []string{"tenant", "id"}, []string{"acme", "7"}},
+		{"Acme.EXAMPLE.com", true, []string{"tenant"}, []string{"Acme"}},
+		{"api-2.prod.example.com", true, []string{"region", "env", "id"}, []string{"2", "prod", "7"}},
+		{"example.com", false, nil, nil},
+		{"a.b.example.com", false, nil, nil},
+		{"acme-1.example.com", false, nil, nil},
+		{"api-2.test.example.com", false, nil, nil},
+		{"acme.example.org", false, nil, nil},
+	}
+	for _, test := range tests {
+		var values url.Values
+		_, err := router.FindHandler("GET", "//"+test.Host+"/api/users/7", &values)
+		if !test.Must {
+			if err != ErrRouteNotFound {
+				t.Errorf("%s: expected not to match", test.Host)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected a match: %s", test.Host, err.Error())
+			continue
+		}
+		for i := range test.Names {
+			if values.Get(test.Names[i]) != test.Value[i] {
+				t.Errorf("%s: expected %s=%q, got %q", test.Host, test.Names[i], test.Value[i], values.Get(test.Names[i]))
+			}
+		}
+	}
+}
+
+func TestRouteGroup(t *testing.T) {
+	router := newRouter()
+	api := router.Group("/api/v1/")
+	api.AddRoute("GET", "/users", testHandler)
+	api.AddRoute("GET", "teams/", testHandler)
+	api.AddRoute("GET", "", testHandler)
+	users := api.Group("users/{uint:id}")
+	users.AddRoute("GET", "/posts", testHandler)
+	users.Group("/").Group("/files/").AddRoute("GET", "{path:name}", testHandler)
+	router.Group("admin").AddRoute("GET", "/stats", testHandler)
+	router.Group("//admin.example.com/api").AddRoute("GET", "/stats", testHandler)
+
+	var tests = []struct {
+		Path string
+		Must bool
+	}{
+		{"/api/v1/users", true},
+		{"/api/v1/teams", true},
+		{"/api/v1", true},
+		{"/api/v1/users/5/posts", true},
+		{"/api/v1/users/5/files/a/b.txt", true},
+		{"/admin/stats", true},
+		{"//admin.example.com/api/stats", true},
+		{"/api/v1//users", true},
+		{"/users", false},
+		{"/api/stats", false},
+	}
+	for _, test := range tests {
+		_, err := router.FindHandler("GET", test.Path, nil)
+		if test.Must && err != nil {
+			t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+		}
+		if !test.Must && err == nil {
+			t.Errorf("%s: expected not to match", test.Path)
+		}
+	}
+}
+
+func TestDeleteRoute(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "/api/users/{word:name}/posts", testHandler)
+	router.AddRoute("PUT", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "/api/files/{path:name}", testHandler)
+	router.AddRoute("GET", "/api/reports/{word:format?}", testHandler)
+	router.AddRoute("DELETE", "//admin.example.com/api/users/{uint:id}", testHandler)
+
+	router.DeleteRoute("PUT", "/api/users/{uint:id}")
+	if _, err := router.FindHandler("PUT", "/api/users/5", nil); err == nil || err.(*StatusError).Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 after delete, got %v", err)
+	}
+	if methods := router.PathMethods("/api/users/5"); methods != "GET, HEAD" {
+		t.Errorf("expected PUT not to be listed, got %q", methods)
+	}
+	for _, method := range router.methods {
+		if method == "PUT" {
+			t.Errorf("expected PUT to be removed from the methods list")
+		}
+	}
+
+	router.DeleteRoute("GET", "/api/users/{uint:id}")
+	if _, err := router.FindHandler("GET", "/api/users/5", nil); err != ErrRouteNotFound {
+		t.Errorf("expected ErrRouteNotFound after delete, got %v", err)
+	}
+	if _, err := router.FindHandler("GET", "/api/users/bob/posts", nil); err != nil {
+		t.Errorf("expected sibling route to match: %s", err.Error())
+	}
+	users := router.root.findLink("GET").findLink("api").findLink("users")
+	if users.numExp != 1 || len(users.links) != 1 {
+		t.Errorf("expected PSE link to be pruned, got numExp=%d links=%d", users.numExp, len(users.links))
+	}
+
+	router.DeleteRoute("GET", "/api/files/{path:name}")
+	if _, err := router.FindHandler("GET", "/api/files/a/b", nil); err != ErrRouteNotFound {
+		t.Errorf("expected ErrRouteNotFound after tail delete, got %v", err)
+	}
+	router.DeleteRoute("GET", "/api/reports/{word:format?}")
+	for _, path := range []string{"/api/reports", "/api/reports/csv"} {
+		if _, err := router.FindHandler("GET", path, nil); err != ErrRouteNotFound {
+			t.Errorf("%s: expected ErrRouteNotFound after optional delete, got %v", path, err)
+		}
+	}
+	api := router.root.findLink("GET").findLink("api")
+	if api.numExp != 0 || api.tail != nil || len(api.links) != 1 {
+		t.Errorf("expected empty subtrees to be pruned, got numExp=%d links=%d", api.numExp, len(api.links))
+	}
+
+	router.DeleteRoute("DELETE", "//admin.example.com/api/users/{uint:id}")
+	if router.hosts != nil {
+		t.Errorf("expected the host trie to be removed")
+	}
+	router.DeleteRoute("GET", "/api/users/{word:name}/posts")
+	if router.root.links != nil || len(router.methods) != 0 {
+		t.Errorf("expected an empty router, got %d links and methods %v", len(router.root.links), router.methods)
+	}
+	// no-op
+	router.DeleteRoute("GET", "/api/users/{word:name}/posts")
+	router.DeleteRoute("PATCH", "//other.example.com/")
+}
+
+func TestDeleteOptionalRoute(t *testing.T) {
+	var route string
+	router := newRouter()
+	router.AddRoute("GET", "/b/{word:x=y}", func(ctx *Context) { route = "/b/{word:x=y}" })
+	router.AddRoute("GET", "/b", func(ctx *Context) { route = "/b" })
+
+	var patterns []string
+	for _, r := range router.ListRoutes() {
+		patterns = append(patterns, r.Pattern)
+	}
+	if got := strings.Join(patterns, " "); got != "/b /b/{word:x=y}" {
+		t.Errorf("unexpected routes: %s", got)
+	}
+	var v url.Values
+	h, err := router.FindHandler("GET", "/b", &v)
+	if err != nil {
+		t.Fatalf("expected /b to match: %s", err.Error())
+	}
+	if h(nil); route != "/b" || v.Get("x") != "" {
+		t.Errorf("expected route /b without defaults, got %s %v", route, v)
+	}
+
+	router.DeleteRoute("GET", "/b/{word:x=y}")
+	if !router.HasRoute("GET", "/b") {
+		t.Error("expected /b to be a route after the optional route is deleted")
+	}
+	if h, err := router.FindHandler("GET", "/b", nil); err != nil {
+		t.Errorf("expected /b to match: %s", err.Error())
+	} else if h(nil); route != "/b" {
+		t.Errorf("expected route /b, got %s", route)
+	}
+	if _, err := router.FindHandler("GET", "/b/z", nil); err != ErrRouteNotFound {
+		t.Errorf("expected ErrRouteNotFound, got %v", err)
+	}
+}
+
+func TestReplaceRoute(t *testing.T) {
+	var n int
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{uint:id}/{word:tab?}", testHandler)
+	router.AddRoute("GET", "/api/users/{word:name}", testHandler)
+	users := router.root.findLink("GET").findLink("api").findLink("users")
+	numExp := users.numExp
+	for i := 0; i < 100; i++ {
+		i := i
+		router.ReplaceRoute("GET", "/api/users/{uint:id}/{word:tab?}", func(ctx *Context) { n = i })
+		router.AddRoute("GET", "/api/users/{word:name}", testHandler)
+	}
+	if users.numExp != numExp || len(users.links) != 2 {
+		t.Errorf("expected numExp=%d and 2 links, got numExp=%d and %d links", numExp, users.numExp, len(users.links))
+	}
+	for _, path := range []string{"/api/users/5", "/api/users/5/posts"} {
+		h, err := router.FindHandler("GET", path, nil)
+		if err != nil {
+			t.Errorf("%s: expected a match: %s", path, err.Error())
+			continue
+		}
+		n = 0
+		if h(nil); n != 99 {
+			t.Errorf("%s: expected the last handler, got %d", path, n)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected ReplaceRoute to panic for a missing route")
+		}
+	}()
+	router.ReplaceRoute("GET", "/api/users/{uint:id}/posts", testHandler)
+}
+
+func TestMethodCase(t *testing.T) {
+	var n int
+	router := newRouter()
+	router.AddRoute("Get", "/api/x", func(ctx *Context) { n = 1 })
+	router.AddRoute("post", "/api/x", func(ctx *Context) { n = 2 })
+	var tests = []struct {
+		Method string
+		N      int
+	}{
+		{"GET", 1},
+		{"get", 1},
+		{"head", 1},
+		{"POST", 2},
+		{"Post", 2},
+	}
+	for _, test := range tests {
+		h, err := router.FindHandler(test.Method, "/api/x", nil)
+		if err != nil {
+			t.Errorf("%s: expected a match: %s", test.Method, err.Error())
+			continue
+		}
+		if h(nil); n != test.N {
+			t.Errorf("%s: expected handler %d, got %d", test.Method, test.N, n)
+		}
+	}
+	if methods := router.PathMethods("/api/x"); methods != "GET, HEAD, POST" {
+		t.Errorf("expected uppercase methods, got %q", methods)
+	}
+	router.ReplaceRoute("gEt", "/api/x", func(ctx *Context) { n = 3 })
+	if h, err := router.FindHandler("GET", "/api/x", nil); err != nil {
+		t.Errorf("expected a match: %s", err.Error())
+	} else if h(nil); n != 3 {
+		t.Errorf("expected the replaced handler, got %d", n)
+	}
+	router.DeleteRoute("POST", "/api/x")
+	router.DeleteRoute("get", "/api/x")
+	if len(router.methods) != 0 {
+		t.Errorf("expected no methods, got %v", router.methods)
+	}
+}
+
+func TestAddRoutes(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GETX", "/api/other", testHandler)
+	router.AddRoutes([]string{"PUT", "patch", "PATCH", "GET", "Put"}, "/api/users/{uint:id}", testHandler)
+	for _, method := range []string{"PUT", "PATCH", "GET", "HEAD"} {
+		if _, err := router.FindHandler(method, "/api/users/5", nil); err != nil {
+			t.Errorf("%s: expected a match: %s", method, err.Error())
+		}
+	}
+	if methods := router.PathMethods("/api/users/5"); methods != "GET, HEAD, PATCH, PUT" {
+		t.Errorf("expected all methods listed once, got %q", methods)
+	}
+	if len(router.methods) != 4 {
+		t.Errorf("expected 4 methods, got %v", router.methods)
+	}
+}
+
+func TestMethodsSubstrings(t *testing.T) {
+	router := newRouter()
+	for _, method := range []string{"GETX", "GET", "INPUT", "PUT", "T"} {
+		router.AddRoute(method, "/api/x", testHandler)
+	}
+	if methods := router.PathMethods("/api/x"); methods != "GET, GETX, HEAD, INPUT, PUT, T" {
+		t.Errorf("expected all methods listed, got %q", methods)
+	}
+}
+
+func TestPathMethodsHead(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("POST", "/api/jobs", testHandler)
+	router.AddRoute("GET", "/api/jobs/{uint:id}", testHandler)
+	router.AddRoute("DELETE", "/api/jobs/{uint:id}", testHandler)
+	var tests = []struct {
+		Path, Methods string
+	}{
+		{"/api/jobs", "POST"},
+		{"/api/jobs/5", "DELETE, GET, HEAD"},
+		{"/api/none", ""},
+	}
+	for _, test := range tests {
+		if methods := router.PathMethods(test.Path); methods != test.Methods {
+			t.Errorf("%s: expected %q, got %q", test.Path, test.Methods, methods)
+		}
+	}
+	h, err := router.FindHandler("OPTIONS", "/api/jobs", nil)
+	if err != nil {
+		t.Fatalf("expected OPTIONS to be answered: %s", err.Error())
+	}
+	w := httptest.NewRecorder()
+	h(&Context{ResponseWriter: w})
+	if allow := w.Header().Get("Allow"); allow != "OPTIONS, POST" {
+		t.Errorf("expected Allow without HEAD, got %q", allow)
+	}
+}
+
+func TestPathMethodsSorted(t *testing.T) {
+	router := newRouter()
+	for _, method := range []string{"PUT", "POST", "head", "GET", "DELETE", "OPTIONS", "PATCH"} {
+		router.AddRoute(method, "/api/items/{uint:id}", testHandler)
+	}
+	for i := 0; i < 3; i++ {
+		if methods := router.PathMethods("/api/items/5"); methods != "DELETE, GET, HEAD, OPTIONS, PATCH, POST, PUT" {
+			t.Errorf("expected sorted methods without duplicates, got %q", methods)
+		}
+	}
+}
+
+func TestPathMethodsSlice(t *testing.T) {
+	router := newRouter()
+	router.AddRoutes([]string{"POST", "GET", "DELETE"}, "/api/items/{uint:id}", testHandler)
+	router.AddRoute("PUT", "/api/tags", testHandler)
+	for _, path := range []string{"/api/items/5", "/api/tags", "/api/none"} {
+		list := router.PathMethodsSlice(path)
+		if methods := router.PathMethods(path); methods != strings.Join(list, ", ") {
+			t.Errorf("%s: expected %q, got %v", path, methods, list)
+		}
+	}
+	if list := router.PathMethodsSlice("/api/items/5"); len(list) != 4 || list[1] != "GET" || list[2] != "HEAD" {
+		t.Errorf("expected the sorted methods, got %v", list)
+	}
+	if list := router.PathMethodsSlice("/api/none"); len(list) != 0 {
+		t.Errorf("expected no methods, got %v", list)
+	}
+}
+
+func TestPathDecode(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{name}", testHandler)
+	router.AddRoute("GET", "/api/users/{name}/{section}", testHandler)
+	router.AddRoute("GET", "/api/pages/about us", testHandler)
+	router.AddRoute("GET", "/api/files/{path:name}", testHandler)
+	var tests = []struct {
+		Path, Name, Value string
+	}{
+		{"/api/users/john%20doe", "name", "john doe"},
+		{"/api/users/a%2Fb", "name", "a/b"},
+		{"/api/users/a%2Fb/c%2fd", "section", "c/d"},
+		{"/api/users/100%", "name", "100%"},
+		{"/api/pages/about%20us", "", ""},
+		{"/api/files/a%20b/c.txt", "name", "a b/c.txt"},
+	}
+	for _, test := range tests {
+		var values url.Values
+		if _, err := router.FindHandler("GET", test.Path, &values); err != nil {
+			t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+			continue
+		}
+		if values.Get(test.Name) != test.Value {
+			t.Errorf("%s: expected %s=%q, got %q", test.Path, test.Name, test.Value, values.Get(test.Name))
+		}
+	}
+
+	svc := NewService("/")
+	svc.Router().AddRoute("GET", "/users/{word:first} {word:last}", func(ctx *Context) {
+		ctx.Header().Set("X-Name", ctx.PathValues.Get("last")+", "+ctx.PathValues.Get("first"))
+	})
+	svc.Router().AddRoute("GET", "/files/{name}", func(ctx *Context) {
+		ctx.Header().Set("X-Name", ctx.PathValues.Get("name"))
+	})
+	for path, name := range map[string]string{
+		"/users/john%20doe": "doe, john",
+		"/files/a%2Fb.txt":  "a/b.txt",
+	} {
+		w := httptest.NewRecorder()
+		svc.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Header().Get("X-Name") != name {
+			t.Errorf("%s: expected %q, got %q (%d)", path, name, w.Header().Get("X-Name"), w.Code)
+		}
+	}
+}
+
+func TestCleanSlashes(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/", testHandler)
+	router.AddRoute("GET", "/api/users/{uint:id}", testHandler)
+	var tests = []struct {
+		Path, Location string
+	}{
+		{"/api//users///5", "/api/users/5"},
+		{"//api/users/5", "/api/users/5"},
+		{"/api/users/5///", "/api/users/5/"},
+		{"////", "/"},
+		{"/api/users/5", ""},
+		{"/", ""},
+	}
+	for _, test := range tests {
+		if _, err := router.FindHandler("GET", test.Path, nil); err != nil {
+			t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+		}
+	}
+
+	router.RedirectCleanPath = true
+	for _, test := range tests {
+		_, err := router.FindHandler("GET", test.Path, nil)
+		if test.Location == "" {
+			if err != nil {
+				t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+			}
+			continue
+		}
+		e, ok := err.(*StatusError)
+		if !ok || e.Code != http.StatusMovedPermanently || e.Details != test.Location {
+			t.Errorf("%s: expected a redirect to %s, got %v", test.Path, test.Location, err)
+		}
+	}
+	router.RedirectTrailingSlash = true
+	_, err := router.FindHandler("GET", "/api/users/5///", nil)
+	if e, ok := err.(*StatusError); !ok || e.Details != "/api/users/5" {
+		t.Errorf("expected a redirect to the path without a trailing slash, got %v", err)
+	}
+	if _, err := router.FindHandler("GET", "/api//none", nil); err != ErrRouteNotFound {
+		t.Errorf("expected ErrRouteNotFound, got %v", err)
+	}
+
+	router.AddRoute("GET", "//admin.example.com/api/stats", testHandler)
+	_, err = router.FindHandler("GET", "//admin.example.com/api//stats", nil)
+	if e, ok := err.(*StatusError); !ok || e.Details != "/api/stats" {
+		t.Errorf("expected a redirect without the host, got %v", err)
+	}
+}
+
+func TestRootPath(t *testing.T) {
+	var route string
+	router := newRouter()
+	for _, path := range []string{"/api/users", "/{word:page?}", "/api/teams/", "//admin.example.com/"} {
+		path := path
+		router.AddRoute("GET", path, func(ctx *Context) { route = path })
+	}
+	router.AddRoute("POST", "/", testHandler)
+	var tests = []struct {
+		Path, Route string
+	}{
+		{"/", "/{word:page?}"},
+		{"", "/{word:page?}"},
+		{"/about", "/{word:page?}"},
+		{"/api/users", "/api/users"},
+		{"/api/teams", "/api/teams/"},
+		{"api/users", "/api/users"},
+		{"//admin.example.com", "//admin.example.com/"},
+		{"//admin.example.com/", "//admin.example.com/"},
+		{"//www.example.com/", "/{word:page?}"},
+	}
+	for _, test := range tests {
+		h, err := router.FindHandler("GET", test.Path, nil)
+		if err != nil {
+			t.Errorf("%q: expected a match: %s", test.Path, err.Error())
+			continue
+		}
+		if h(nil); route != test.Route {
+			t.Errorf("%q: expected route %s, got %s", test.Path, test.Route, route)
+		}
+	}
+	if methods := router.PathMethods("/"); methods != "GET, HEAD, POST" {
+		t.Errorf("expected root methods, got %q", methods)
+	}
+	if _, err := router.FindHandler("POST", "/", nil); err != nil {
+		t.Errorf("expected POST / to match: %s", err.Error())
+	}
+
+	svc := NewService("/")
+	svc.Router().AddRoute("GET", "/", func(ctx *Context) { ctx.WriteHeader(http.StatusAccepted) })
+	w := httptest.NewRecorder()
+	if svc.ServeHTTP(w, httptest.NewRequest("GET", "/", nil)); w.Code != http.StatusAccepted {
+		t.Errorf("expected the root handler, got %d", w.Code)
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	var route string
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{uint:id}", func(ctx *Context) { route = "user" })
+	router.SetNotFoundHandler(func(ctx *Context) { route = "fallback" })
+	var tests = []struct {
+		Method, Path, Route string
+	}{
+		{"GET", "/api/users/5", "user"},
+		{"GET", "/api/users/bob", "fallback"},
+		{"GET", "/index.html", "fallback"},
+		{"GET", "/", "fallback"},
+		{"GET", "//other.example.com/x", "fallback"},
+	}
+	for _, test := range tests {
+		h, err := router.FindHandler(test.Method, test.Path, nil)
+		if err != nil {
+			t.Errorf("%s: expected a handler: %s", test.Path, err.Error())
+			continue
+		}
+		if h(nil); route != test.Route {
+			t.Errorf("%s: expected %s, got %s", test.Path, test.Route, route)
+		}
+	}
+	if _, err := router.FindHandler("DELETE", "/api/users/5", nil); err == nil || err.(*StatusError).Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 for a known path, got %v", err)
+	}
+	router.SetNotFoundHandler(nil)
+	if _, err := router.FindHandler("GET", "/index.html", nil); err != ErrRouteNotFound {
+		t.Errorf("expected ErrRouteNotFound without a fallback, got %v", err)
+	}
+}
+
+func TestMethodNotAllowedHandler(t *testing.T) {
+	var route string
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{uint:id}", func(ctx *Context) { route = "user" })
+	router.AddRoute("PUT", "/api/users/{uint:id}", func(ctx *Context) { route = "user" })
+	router.SetNotFoundHandler(func(ctx *Context) { route = "not found" })
+	router.SetMethodNotAllowedHandler(func(ctx *Context) {
+		route = "bad method"
+		ctx.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	var tests = []struct {
+		Method, Path, Route, Allow string
+	}{
+		{"GET", "/api/users/5", "user", ""},
+		{"DELETE", "/api/users/5", "bad method", "GET, HEAD, PUT"},
+		{"DELETE", "/api/teams/5", "not found", ""},
+		{"GET", "/api/users/bob", "not found", ""},
+	}
+	for _, test := range tests {
+		h, err := router.FindHandler(test.Method, test.Path, nil)
+		if err != nil {
+			t.Errorf("%s %s: expected a handler: %s", test.Method, test.Path, err.Error())
+			continue
+		}
+		w := httptest.NewRecorder()
+		if h(&Context{ResponseWriter: w}); route != test.Route {
+			t.Errorf("%s %s: expected %s, got %s", test.Method, test.Path, test.Route, route)
+		}
+		if allow := w.Header().Get("Allow"); allow != test.Allow {
+			t.Errorf("%s %s: expected Allow %q, got %q", test.Method, test.Path, test.Allow, allow)
+		}
+	}
+	router.SetMethodNotAllowedHandler(nil)
+	if _, err := router.FindHandler("DELETE", "/api/users/5", nil); err == nil || err.(*StatusError).Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 without a handler, got %v", err)
+	}
+}
+
+func TestBadMethodOrNotFound(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/", testHandler)
+	router.AddRoute("GET", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("POST", "/api/users", testHandler)
+	router.AddRoute("GET", "/api/files/{path:name}", testHandler)
+	var tests = []struct {
+		Method, Path string
+		Code         int
+	}{
+		{"GET", "/api/unknown", http.StatusNotFound},
+		{"GET", "/api", http.StatusNotFound},
+		{"POST", "/api/unknown", http.StatusNotFound},
+		{"PATCH", "/api/unknown", http.StatusNotFound},
+		{"GET", "/api/users", http.StatusMethodNotAllowed},
+		{"POST", "/api/users/5", http.StatusMethodNotAllowed},
+		{"PATCH", "/api/users/5", http.StatusMethodNotAllowed},
+		{"POST", "/api/files/a/b.txt", http.StatusMethodNotAllowed},
+		{"POST", "/", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		_, err := router.FindHandler(test.Method, test.Path, nil)
+		if e, ok := err.(*StatusError); !ok || e.Code != test.Code {
+			t.Errorf("%s %s: expected status %d, got %v", test.Method, test.Path, test.Code, err)
+		}
+	}
+}
+
+func TestHeadRoute(t *testing.T) {
+	var route string
+	router := newRouter()
+	router.AddRoute("GET", "/api/files/{word:name}", func(ctx *Context) { route = "get file" })
+	router.AddRoute("HEAD", "/api/files/{word:name}", func(ctx *Context) { route = "head file" })
+	router.AddRoute("GET", "/api/users/{uint:id}", func(ctx *Context) { route = "get user" })
+	router.AddRoute("HEAD", "/api/stats", func(ctx *Context) { route = "head stats" })
+	var tests = []struct {
+		Method, Path, Route string
+	}{
+		{"HEAD", "/api/files/a", "head file"},
+		{"GET", "/api/files/a", "get file"},
+		{"HEAD", "/api/users/5", "get user"},
+		{"head", "/api/users/5", "get user"},
+		{"HEAD", "/api/stats", "head stats"},
+	}
+	for _, test := range tests {
+		h, err := router.FindHandler(test.Method, test.Path, nil)
+		if err != nil {
+			t.Errorf("%s %s: expected a match: %s", test.Method, test.Path, err.Error())
+			continue
+		}
+		if h(nil); route != test.Route {
+			t.Errorf("%s %s: expected %s, got %s", test.Method, test.Path, test.Route, route)
+		}
+	}
+	if _, err := router.FindHandler("GET", "/api/stats", nil); err == nil || err.(*StatusError).Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 for GET on a HEAD route, got %v", err)
+	}
+	if methods := router.PathMethods("/api/files/a"); methods != "GET, HEAD" {
+		t.Errorf("expected HEAD listed once, got %q", methods)
+	}
+	router.RedirectTrailingSlash = true
+	if _, err := router.FindHandler("HEAD", "/api/files/a/", nil); err == nil || err.(*StatusError).Code != http.StatusMovedPermanently {
+		t.Errorf("expected status 301 for a HEAD redirect, got %v", err)
+	}
+}
+
+func TestAddRouteInvalid(t *testing.T) {
+	var tests = []struct {
+		Method, Path string
+	}{
+		{"", "/x"},
+		{"GET", ""},
+		{"GET", "x"},
+		{"GET", "api/x"},
+		{"G T", "/x"},
+		{"GET/", "/x"},
+		{"\tGET", "/x"},
+	}
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%q %q: expected a panic", test.Method, test.Path)
+				} else if msg, ok := r.(string); !ok || !strings.HasPrefix(msg, "relax: ") {
+					t.Errorf("%q %q: expected a relax panic, got %v", test.Method, test.Path, r)
+				}
+			}()
+			newRouter().AddRoute(test.Method, test.Path, testHandler)
+		}()
+	}
+}
+
+func TestSegmentExpError(t *testing.T) {
+	var tests = []string{
+		"{re:([}",
+		"{rei:a(b}",
+		"({word:name}",
+	}
+	for _, pattern := range tests {
+		rx, err := compileSegment(pattern)
+		if err == nil || rx != nil {
+			t.Errorf("%s: expected an error", pattern)
+			continue
+		}
+		if msg := err.Error(); !strings.HasPrefix(msg, "relax: ") || !strings.Contains(msg, strconv.Quote(pattern)) {
+			t.Errorf("%s: expected the error to name the segment, got %q", pattern, msg)
+		}
+	}
+	if _, err := compileSegment("{re:([0-9]+)}"); err != nil {
+		t.Errorf("expected a valid pattern to compile: %s", err.Error())
+	}
+
+	router := newRouter()
+	func() {
+		defer func() {
+			msg, _ := recover().(string)
+			if !strings.HasPrefix(msg, "relax: invalid path segment \"{re:([}\"") {
+				t.Errorf("expected a descriptive panic, got %q", msg)
+			}
+		}()
+		router.AddRoute("GET", "/api/{re:([}/x", testHandler)
+	}()
+	if router.root.links != nil || router.methods != nil {
+		t.Errorf("expected the tree unchanged by an invalid route")
+	}
+}
+
+func TestValueGroups(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/events/{date:when}", testHandler)
+	router.AddRoute("GET", "/cities/{geo:location}", testHandler)
+	router.AddRoute("GET", "/custom/{re:(?P<a>x(?P<b>y))}", testHandler)
+	router.AddRoute("GET", "/months/{rei:(?P<mon>jan|feb)}", testHandler)
+	var tests = []struct {
+		Path   string
+		Values map[string]string
+	}{
+		{"/events/2024-01-31", map[string]string{"when": "2024-01-31"}},
+		{"/events/2024-01-31T10:20:30Z", map[string]string{"when": "2024-01-31T10:20:30Z"}},
+		{"/cities/37.786971,-122.399677;u=35", map[string]string{"location": "37.786971,-122.399677;u=35"}},
+		{"/custom/xy", map[string]string{"a": "xy"}},
+		{"/months/JAN", map[string]string{"mon": "JAN"}},
+	}
+	for _, test := range tests {
+		var values url.Values
+		if _, err := router.FindHandler("GET", test.Path, &values); err != nil {
+			t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+			continue
+		}
+		for k := range values {
+			if k[0] == '_' || k == RoutePattern {
+				continue
+			}
+			if v, ok := test.Values[k]; !ok || values.Get(k) != v {
+				t.Errorf("%s: unexpected value %s=%q", test.Path, k, values.Get(k))
+			}
+		}
+		for k, v := range test.Values {
+			if values.Get(k) != v {
+				t.Errorf("%s: expected %s=%q, got %q", test.Path, k, v, values.Get(k))
+			}
+		}
+	}
+}
+
+func TestPositionalValues(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/events/{date:when}/{uint:id}", testHandler)
+	router.AddRoute("GET", "/cities/{geo:location}/{word:name}/{re:(a|b)(c|d)}/{path:rest}", testHandler)
+	router.AddRoute("GET", "//{word:tenant}.example.com/{rei:(?P<mon>jan|feb)}/{uint:id}", testHandler)
+	var tests = []struct {
+		Path   string
+		Values []string
+	}{
+		{"/events/2024-01-31T10:20:30Z/5", []string{"2024-01-31T10:20:30Z", "5"}},
+		{"/cities/37.78,-122.39/sf/bd/x/y", []string{"37.78,-122.39", "sf", "b", "d", "x/y"}},
+		{"//acme.example.com/feb/7", []string{"acme", "feb", "7"}},
+	}
+	for _, test := range tests {
+		var values url.Values
+		if _, err := router.FindHandler("GET", test.Path, &values); err != nil {
+			t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+			continue
+		}
+		n := 0
+		for k := range values {
+			if k[0] == '_' {
+				n++
+			}
+		}
+		if n != len(test.Values) {
+			t.Errorf("%s: expected %d positional values, got %d: %v", test.Path, len(test.Values), n, values)
+		}
+		for i, v := range test.Values {
+			if _n := "_" + strconv.Itoa(i+1); values.Get(_n) != v {
+				t.Errorf("%s: expected %s=%q, got %q", test.Path, _n, v, values.Get(_n))
+			}
+		}
+	}
+}
+
+func TestLargeUint(t *testing.T) {
+	var id string
+	router := newRouter()
+	router.AddRoute("GET", "/api/messages/{uint:id}", func(ctx *Context) { id = ctx.PathValues.Get("id") })
+	var values url.Values
+	h, err := router.FindHandler("GET", "/api/messages/18446744073709551616", &values)
+	if err != nil {
+		t.Fatalf("expected a 20-digit id to match: %s", err.Error())
+	}
+	if h(&Context{PathValues: values}); id != "18446744073709551616" {
+		t.Errorf("expected the handler to get the id, got %q", id)
+	}
+}
+
+func TestSegmentUUIDVersion(t *testing.T) {
+	for _, pattern := range []string{"{uuid:id:0}", "{uuid:id:9}", "{uuid:id:12}"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", pattern)
+				}
+			}()
+			segmentExp(pattern)
+		}()
+	}
+}
+
+func TestConcurrentCache(t *testing.T) {
+	serving := newRouter()
+	serving.AddRoute("GET", "/users/{uint:id}", testHandler)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			router := newRouter()
+			for j := 0; j < 50; j++ {
+				router.AddRoute("GET", "/r"+strconv.Itoa(i)+"/{hex:h"+strconv.Itoa(j)+"}", testHandler)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var values url.Values
+				if _, err := serving.FindHandler("GET", "/users/"+strconv.Itoa(j), &values); err != nil {
+					t.Errorf("expected a match: %s", err.Error())
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRouterCache(t *testing.T) {
+	a, b := newRouter(), newRouter()
+	a.AddRoute("GET", "/users/{uint:id}", testHandler)
+	if rx, _ := b.cache.load("{uint:id}"); rx != nil {
+		t.Errorf("expected no shared pattern in a new router")
+	}
+	b.AddRoute("GET", "/users/{uint:id}", testHandler)
+	ra, _ := a.cache.load("{uint:id}")
+	rb, _ := b.cache.load("{uint:id}")
+	if ra == nil || rb == nil {
+		t.Fatalf("expected each router to cache its pattern")
+	}
+	if ra == rb {
+		t.Errorf("expected each router to compile its own pattern")
+	}
+	c := newRouter()
+	c.CaseInsensitive = true
+	c.AddRoute("GET", "/users/{word:name}", testHandler)
+	if rx, _ := a.cache.load("(?i){word:name}"); rx != nil {
+		t.Errorf("expected case-insensitive pattern only in its router")
+	}
+}
+
+func TestConcurrentRoutes(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/users/{uint:id}", testHandler)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				path := "/r" + strconv.Itoa(i) + "/{hex:h" + strconv.Itoa(j) + "}"
+				router.AddRoute("GET", path, testHandler)
+				router.AddRoute("PUT", path, testHandler)
+				if j%2 == 0 {
+					router.DeleteRoute("PUT", path)
+				}
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var values url.Values
+				if _, err := router.FindHandler("GET", "/users/"+strconv.Itoa(j), &values); err != nil {
+					t.Errorf("expected a match: %s", err.Error())
+					return
+				}
+				router.FindHandler("GET", "/r"+strconv.Itoa(i)+"/ff", nil)
+				router.PathMethods("/r" + strconv.Itoa(i) + "/ff")
+			}
+		}(i)
+	}
+	wg.Wait()
+	if methods := router.PathMethods("/r0/ff"); methods != "GET, HEAD, PUT" {
+		t.Errorf("unexpected methods %q", methods)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	for _, tt := range testSegments {
+		router := newRouter()
+		router.AddRoute("GET", tt.Route, testHandler)
+		var v url.Values
+		_, err := router.Freeze().FindHandler("GET", tt.Path, &v)
+		if (err == nil) != tt.Must {
+			t.Errorf("%s: expected %q match=%v in frozen router", tt.Route, tt.Path, tt.Must)
+			continue
+		}
+		if tt.Must && tt.Name != "" && v.Get(tt.Name) != tt.Value {
+			t.Errorf("%s: expected %s=%q, got %q", tt.Route, tt.Name, tt.Value, v.Get(tt.Name))
+		}
+	}
+
+	router := newRouter()
+	router.CaseInsensitive = true
+	router.AddRoute("GET", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "/api/files/{path:rest}", testHandler)
+	router.AddRoute("GET", "//{word:tenant}.example.com/stats", testHandler)
+	frozen := router.Freeze().(*TrieRegexpRouter)
+	router.AddRoute("GET", "/api/posts", testHandler)
+	router.DeleteRoute("GET", "/api/users/{uint:id}")
+
+	for path, name := range map[string]string{
+		"/API/Users/5":                    "id",
+		"/api/files/a/b.txt":              "rest",
+		"//acme.example.com/stats":        "tenant",
+		"//ACME.example.com/STATS":        "tenant",
+		"//other.example.com/api/users/5": "id",
+	} {
+		var v url.Values
+		if _, err := frozen.FindHandler("GET", path, &v); err != nil {
+			t.Errorf("%s: expected a match in frozen router: %s", path, err.Error())
+			continue
+		}
+		if v.Get(name) == "" {
+			t.Errorf("%s: expected a value for %s", path, name)
+		}
+	}
+	if _, err := frozen.FindHandler("GET", "/api/posts", nil); err != ErrRouteNotFound {
+		t.Errorf("expected routes added after Freeze not to match")
+	}
+	if methods := frozen.PathMethods("/api/users/5"); methods != "GET, HEAD" {
+		t.Errorf("unexpected frozen methods %q", methods)
+	}
+
+	for name, change := range map[string]func(){
+		"AddRoute":     func() { frozen.AddRoute("GET", "/api/posts", testHandler) },
+		"ReplaceRoute": func() { frozen.ReplaceRoute("GET", "/api/users/{uint:id}", testHandler) },
+		"DeleteRoute":  func() { frozen.DeleteRoute("GET", "/api/users/{uint:id}") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic in frozen router", name)
+				}
+			}()
+			change()
+		}()
+	}
+}
+
+// deepRoutes are routes of a deep static API, with long string prefixes.
+var deepRoutes = []string{
+	"/api/internal/v2/admin/users",
+	"/api/internal/v2/admin/users/{uint:id}",
+	"/api/internal/v2/admin/groups/list",
+	"/api/internal/v2/billing/invoices/pending",
+	"/api/internal/v2/billing/{word:kind}/summary",
+	"/api/public/v1/status",
+	"/api/public/v1/docs/{path:page}",
+}
+
+func TestCompact(t *testing.T) {
+	router := newRouter()
+	for _, route := range deepRoutes {
+		router.AddRoute("GET", route, testHandler)
+	}
+	router.AddRoute("POST", "/api/internal/v2/admin/groups/list", testHandler)
+	router.AddRoute("GET", "//{word:tenant}.example.com/a/b/c/d", testHandler)
+	frozen := router.Freeze().(*TrieRegexpRouter)
+
+	for _, path := range []string{
+		"/api/internal/v2/admin/users",
+		"/api/internal/v2/admin/users/5",
+		"/api/internal/v2/admin/groups/list",
+		"/api/internal/v2/admin/groups",
+		"/api/internal/v2/admin/groups/list/more",
+		"/api/internal/v2/billing/invoices/pending",
+		"/api/internal/v2/billing/invoices/summary",
+		"/api/internal/v2/billing/credits/summary",
+		"/api/internal/v2/billing/invoices",
+		"/api/internal/v2/adm%69n/users",
+		"/api/public/v1/status",
+		"/api/public/v1/docs/a/b.html",
+		"/api/public/v1",
+		"/api/public/v2/status",
+		"//acme.example.com/a/b/c/d",
+		"//acme.example.com/a/b/c",
+	} {
+		var v, fv url.Values
+		_, err := router.FindHandler("GET", path, &v)
+		_, ferr := frozen.FindHandler("GET", path, &fv)
+		if (err == nil) != (ferr == nil) {
+			t.Errorf("%s: expected the same match in frozen router, got %v and %v", path, err, ferr)
+			continue
+		}
+		if v.Encode() != fv.Encode() {
+			t.Errorf("%s: expected values %q in frozen router, got %q", path, v.Encode(), fv.Encode())
+		}
+	}
+
+	if !frozen.HasRoute("GET", "/api/internal/v2/admin/users/{uint:id}") || frozen.HasRoute("GET", "/api/internal/v2/admin") {
+		t.Error("expected HasRoute to find the routes in frozen router")
+	}
+	if names := frozen.ParamNames("GET", "/api/internal/v2/billing/{word:kind}/summary"); len(names) != 1 || names[0] != "kind" {
+		t.Errorf("unexpected ParamNames %v in frozen router", names)
+	}
+	if a, b := len(router.ListRoutes()), len(frozen.ListRoutes()); a != b {
+		t.Errorf("expected %d routes listed in frozen router, got %d", a, b)
+	}
+	for _, route := range frozen.ListRoutes() {
+		if !router.HasRoute(route.Method, route.Pattern) {
+			t.Errorf("unexpected route %s %q in frozen router", route.Method, route.Pattern)
+		}
+	}
+	if a, b := len(router.ConflictingRoutes()), len(frozen.ConflictingRoutes()); a != b {
+		t.Errorf("expected %d conflicts in frozen router, got %d", a, b)
+	}
+
+	clone := frozen.Clone()
+	clone.AddRoute("GET", "/api/internal/v2/admin", testHandler)
+	clone.AddRoute("GET", "/api/internal/v3/admin", testHandler)
+	for _, path := range []string{"/api/internal/v2/admin", "/api/internal/v3/admin", "/api/internal/v2/admin/users"} {
+		if _, err := clone.FindHandler("GET", path, nil); err != nil {
+			t.Errorf("%s: expected a match in clone of frozen router: %s", path, err.Error())
+		}
+	}
+
+	if a, b := countNodes(router.root), countNodes(frozen.root); b >= a {
+		t.Errorf("expected less nodes in frozen router, got %d and %d", a, b)
+	}
+}
+
+// countNodes returns the number of nodes in the tree of 'n'.
+func countNodes(n *trieNode) int {
+	count := 1
+	for _, link := range n.links {
+		count += countNodes(link)
+	}
+	return count
+}
+
+func benchDeepStatic(b *testing.B, frozen bool) {
+	router := newRouter()
+	for _, route := range deepRoutes {
+		router.AddRoute("GET", route, testHandler)
+	}
+	if frozen {
+		router = router.Freeze().(*TrieRegexpRouter)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := router.FindHandler("GET", "/api/internal/v2/billing/invoices/pending", nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(countNodes(router.root)), "nodes")
+}
+
+func BenchmarkDeepStatic(b *testing.B) {
+	benchDeepStatic(b, false)
+}
+
+func BenchmarkDeepStaticFrozen(b *testing.B) {
+	benchDeepStatic(b, true)
+}
+
+// benchRoutes are a mix of string, PSE and tail routes for the benchmarks.
+var benchRoutes = []string{
+	"/api/users",
+	"/api/users/{uint:id}",
+	"/api/users/{uint:id}/posts",
+	"/api/users/{uint:id}/posts/{uint:pid}",
+	"/api/users/{word:name}/profile",
+	"/api/posts/{date:day}",
+	"/api/tags/{enum:kind:news|blog|wiki}",
+	"/api/files/{path:rest}",
+	"/static/css/site.css",
+	"/static/js/app.js",
+}
+
+var benchPaths = []string{
+	"/api/users",
+	"/api/users/123",
+	"/api/users/123/posts/456",
+	"/api/users/alice/profile",
+	"/api/posts/2024-01-31",
+	"/api/tags/blog",
+	"/api/files/a/b/c.txt",
+	"/static/js/app.js",
+}
+
+func benchRouter() *TrieRegexpRouter {
+	router := newRouter()
+	for _, path := range benchRoutes {
+		router.AddRoute("GET", path, testHandler)
+	}
+	return router
+}
+
+func benchFindHandler(b *testing.B, router Router) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var values url.Values
+		if _, err := router.FindHandler("GET", benchPaths[i%len(benchPaths)], &values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// apiResources are the resources of the apiRoutes table.
+var apiResources = []string{
+	"users", "groups", "roles", "permissions", "sessions", "tokens",
+	"accounts", "invoices", "payments", "refunds", "orders", "carts",
+	"products", "categories", "reviews", "comments", "posts", "tags",
+	"files", "folders", "messages", "threads", "notifications", "events",
+	"locations", "devices", "reports", "exports", "webhooks", "widgets",
+}
+
+// apiRoutes returns a realistic route table for the benchmarks; the routes
+// of a REST API, a few hundred, as method and path.
+func apiRoutes() [][2]string {
+	var routes [][2]string
+	for _, r := range apiResources {
+		prefix := "/api/v1/" + r
+		routes = append(routes,
+			[2]string{"GET", prefix},
+			[2]string{"POST", prefix},
+			[2]string{"GET", prefix + "/{uint:id}"},
+			[2]string{"PUT", prefix + "/{uint:id}"},
+			[2]string{"DELETE", prefix + "/{uint:id}"},
+			[2]string{"GET", prefix + "/{uint:id}/history/{date:day}"},
+			[2]string{"GET", prefix + "/near/{geo:location}"},
+			[2]string{"GET", prefix + "/{uint:id}/related/{word:kind}/{uint:rid}"},
+			[2]string{"GET", "/api/v1/admin/reports/" + r + "/daily/summary"},
+		)
+	}
+	return routes
+}
+
+func BenchmarkFindHandler(b *testing.B) {
+	b.Run("mixed", func(b *testing.B) {
+		benchFindHandler(b, benchRouter())
+	})
+	router := newRouter()
+	for _, route := range apiRoutes() {
+		router.AddRoute(route[0], route[1], testHandler)
+	}
+	for _, bench := range []struct {
+		name, path string
+	}{
+		{"static", "/api/v1/widgets"},
+		{"single_pse", "/api/v1/widgets/123"},
+		{"multi_pse_date", "/api/v1/widgets/123/history/2024-01-31"},
+		{"multi_pse_geo", "/api/v1/widgets/near/37.786971,-122.399677;u=35"},
+		{"multi_pse_deep", "/api/v1/widgets/123/related/owner/456"},
+		{"deep_static", "/api/v1/admin/reports/widgets/daily/summary"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var values url.Values
+				if _, err := router.FindHandler("GET", bench.path, &values); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPathSegments(t *testing.T) {
+	for path, segs := range map[string]string{
+		"":             "GET",
+		"/":            "GET",
+		"///":          "GET",
+		"/api":         "GET api",
+		"api/users":    "GET api users",
+		"/api/users/":  "GET api users",
+		"/api//users":  "GET api  users",
+		"/{uint:id}/x": "GET {uint:id} x",
+	} {
+		if got := strings.Join(pathSegments("GET", path), " "); got != segs {
+			t.Errorf("%q: expected segments %q, got %q", path, segs, got)
+		}
+	}
+}
+
+// BenchmarkAddRouteCommonPrefix reports the heap kept by each route of a table
+// with a common prefix and the same PSE's.
+func BenchmarkAddRouteCommonPrefix(b *testing.B) {
+	var (
+		stats runtime.MemStats
+		heap  uint64
+	)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		before := stats.HeapAlloc
+		router := newRouter()
+		router.CaseInsensitive = true
+		for j := 0; j < 500; j++ {
+			router.AddRoute("GET", "/api/v1/accounts/"+strconv.Itoa(j)+"/users/{uint:id}/settings", testHandler)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		heap += stats.HeapAlloc - before
+		runtime.KeepAlive(router)
+	}
+	b.ReportMetric(float64(heap)/float64(b.N)/500, "heap-B/route")
+}
+
+func BenchmarkAddRoute(b *testing.B) {
+	routes := apiRoutes()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		router := newRouter()
+		for _, route := range routes {
+			router.AddRoute(route[0], route[1], testHandler)
+		}
+	}
+}
+
+func BenchmarkFindHandlerFrozen(b *testing.B) {
+	benchFindHandler(b, benchRouter().Freeze())
+}
+
+func BenchmarkFindHandlerParallel(b *testing.B) {
+	router := benchRouter()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			router.FindHandler("GET", benchPaths[i%len(benchPaths)], nil)
+		}
+	})
+}
+
+func BenchmarkFindHandlerFrozenParallel(b *testing.B) {
+	router := benchRouter().Freeze()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			router.FindHandler("GET", benchPaths[i%len(benchPaths)], nil)
+		}
+	})
+}
+
+func TestClone(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "/api/posts/{word:slug}", testHandler)
+	router.AddRoute("GET", "//{word:tenant}.example.com/stats", testHandler)
+
+	clone := router.Clone().(*TrieRegexpRouter)
+	clone.AddRoute("PUT", "/api/users/{uint:id}", testHandler)
+	clone.AddRoute("GET", "/api/tags/{word:tag}", testHandler)
+	clone.DeleteRoute("GET", "/api/posts/{word:slug}")
+	clone.DeleteRoute("GET", "//{word:tenant}.example.com/stats")
+
+	for _, path := range []string{"/api/users/5", "/api/posts/hello", "//acme.example.com/stats"} {
+		if _, err := router.FindHandler("GET", path, nil); err != nil {
+			t.Errorf("%s: expected a match in the original: %s", path, err.Error())
+		}
+	}
+	if _, err := router.FindHandler("GET", "/api/tags/go", nil); err != ErrRouteNotFound {
+		t.Errorf("expected routes added to the clone not to match in the original")
+	}
+	if methods := router.PathMethods("/api/users/5"); methods != "GET, HEAD" {
+		t.Errorf("unexpected methods in the original %q", methods)
+	}
+
+	if _, err := clone.FindHandler("GET", "/api/tags/go", nil); err != nil {
+		t.Errorf("expected a match in the clone: %s", err.Error())
+	}
+	if _, err := clone.FindHandler("GET", "/api/posts/hello", nil); err != ErrRouteNotFound {
+		t.Errorf("expected routes deleted from the clone not to match in the clone")
+	}
+	if methods := clone.PathMethods("/api/users/5"); methods != "GET, HEAD, PUT" {
+		t.Errorf("unexpected methods in the clone %q", methods)
+	}
+
+	thawed := router.Freeze().(*TrieRegexpRouter).Clone()
+	thawed.AddRoute("GET", "/api/tags/{word:tag}", testHandler)
+	if _, err := thawed.FindHandler("GET", "/api/tags/go", nil); err != nil {
+		t.Errorf("expected the clone of a frozen router to be changed: %s", err.Error())
+	}
+}
+
+func TestURL(t *testing.T) {
+	router := newRouter()
+	router.AddNamedRoute("user", "GET", "/api/users/{uint:id}", testHandler)
+	router.AddNamedRoute("user", "PUT", "/api/users/{uint:id}", testHandler)
+	router.AddNamedRoute("handle", "GET", "/api/@{word:name}/posts/{date:day}", testHandler)
+	router.AddNamedRoute("file", "GET", "/files/{path:rest}", testHandler)
+	router.AddNamedRoute("page", "GET", "/pages/{uint:n=1}", testHandler)
+	router.AddNamedRoute("format", "GET", "/formats/{re:(json|xml)}", testHandler)
+	router.AddNamedRoute("tenant", "GET", "//{word:tenant}.example.com/stats/*", testHandler)
+	router.AddNamedRoute("root", "GET", "/", testHandler)
+
+	var tests = []struct {
+		Name   string
+		Params map[string]string
+		Path   string
+		Must   bool
+	}{
+		{"user", map[string]string{"id": "5"}, "/api/users/5", true},
+		{"user", map[string]string{"_1": "5"}, "/api/users/5", true},
+		{"user", map[string]string{"id": "abc"}, "", false},
+		{"user", map[string]string{"uid": "5"}, "", false},
+		{"user", nil, "", false},
+		{"handle", map[string]string{"name": "alice", "day": "2024-01-31"}, "/api/@alice/posts/2024-01-31", true},
+		{"handle", map[string]string{"name": "alice", "day": "yesterday"}, "", false},
+		{"file", map[string]string{"rest": "docs/a b.txt"}, "/files/docs/a%20b.txt", true},
+		{"page", map[string]string{"n": "3"}, "/pages/3", true},
+		{"page", nil, "/pages", true},
+		{"format", map[string]string{"_1": "xml"}, "/formats/xml", true},
+		{"format", map[string]string{"_1": "yaml"}, "", false},
+		{"tenant", map[string]string{"tenant": "acme", "wild": "x"}, "//acme.example.com/stats/x", true},
+		{"tenant", map[string]string{"tenant": "a.b", "wild": "x"}, "", false},
+		{"root", nil, "/", true},
+		{"missing", nil, "", false},
+	}
+	for _, tt := range tests {
+		path, err := router.URL(tt.Name, tt.Params)
+		if !tt.Must {
+			if err == nil {
+				t.Errorf("%s %v: expected an error, got %q", tt.Name, tt.Params, path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %v: unexpected error: %s", tt.Name, tt.Params, err.Error())
+			continue
+		}
+		if path != tt.Path {
+			t.Errorf("%s %v: expected %q, got %q", tt.Name, tt.Params, tt.Path, path)
+			continue
+		}
+		if _, err := router.FindHandler("GET", path, nil); err != nil {
+			t.Errorf("%s: expected %q to match its route: %s", tt.Name, path, err.Error())
+		}
+	}
+
+	router.DeleteRoute("GET", "/api/users/{uint:id}")
+	if _, err := router.URL("user", map[string]string{"id": "5"}); err != nil {
+		t.Errorf("expected the name to remain with a route left: %s", err.Error())
+	}
+	router.DeleteRoute("PUT", "/api/users/{uint:id}")
+	if _, err := router.URL("user", map[string]string{"id": "5"}); err == nil {
+		t.Errorf("expected the name to be removed with its routes")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic for a name used with another path")
+		}
+	}()
+	router.AddNamedRoute("file", "GET", "/other/{path:rest}", testHandler)
+}
+
+func TestListRoutes(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/", testHandler)
+	router.AddRoute("GET", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "/api/users/{word:name}", testHandler)
+	router.AddRoute("GET", "/api/users", testHandler)
+	router.AddRoute("GET", "/api/reports/{word:format?}", testHandler)
+	router.AddRoute("GET", "/api/exports/{word:format=json}", testHandler)
+	router.AddRoute("GET", "/files/{path:rest}", testHandler)
+	router.AddRoute("post", "/api/users", testHandler)
+	router.AddNamedRoute("user", "PUT", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "//{word:tenant}.example.com/stats", testHandler)
+	router.AddRoute("DELETE", "/api/users/{uint:id}", testHandler)
+	router.DeleteRoute("DELETE", "/api/users/{uint:id}")
+
+	expected := []RouteInfo{
+		{Method: "GET", Pattern: "/"},
+		{Method: "GET", Pattern: "/api/users"},
+		{Method: "GET", Pattern: "/api/users/{uint:id}", Name: "user"},
+		{Method: "GET", Pattern: "/api/users/{word:name}"},
+		{Method: "GET", Pattern: "/api/reports/{word:format?}"},
+		{Method: "GET", Pattern: "/api/exports/{word:format=json}"},
+		{Method: "GET", Pattern: "/files/{path:rest}"},
+		{Method: "POST", Pattern: "/api/users"},
+		{Method: "PUT", Pattern: "/api/users/{uint:id}", Name: "user"},
+		{Method: "GET", Pattern: "//{word:tenant}.example.com/stats"},
+	}
+	routes := router.ListRoutes()
+	if len(routes) != len(expected) {
+		t.Fatalf("expected %d routes, got %d: %v", len(expected), len(routes), routes)
+	}
+	for i := range expected {
+		if routes[i].Method != expected[i].Method || routes[i].Pattern != expected[i].Pattern || routes[i].Name != expected[i].Name {
+			t.Errorf("route %d: expected %s %s %q, got %s %s %q", i,
+				expected[i].Method, expected[i].Pattern, expected[i].Name,
+				routes[i].Method, routes[i].Pattern, routes[i].Name)
+		}
+		if routes[i].Handler == nil {
+			t.Errorf("route %d: expected a handler", i)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{word:name}", testHandler)
+	router.AddRoute("GET", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "/api/users/me", testHandler)
+	router.AddRoute("GET", "/api/posts/{date:day}", testHandler)
+	router.AddRoute("PUT", "/api/users/{uint:id}", testHandler)
+
+	expected := []string{
+		"GET /api/users/me",
+		"GET /api/users/{uint:id}",
+		"GET /api/users/{word:name}",
+		"GET /api/posts/{date:day}",
+		"PUT /api/users/{uint:id}",
+	}
+	var walked []string
+	router.Walk(func(method, pattern string, handler HandlerFunc) bool {
+		if handler == nil {
+			t.Errorf("%s %s: expected a handler", method, pattern)
+		}
+		walked = append(walked, method+" "+pattern)
+		return true
+	})
+	if strings.Join(walked, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected walk order %q, got %q", expected, walked)
+	}
+
+	for stop := 1; stop <= len(expected); stop++ {
+		n := 0
+		router.Walk(func(method, pattern string, handler HandlerFunc) bool {
+			n++
+			return n < stop
+		})
+		if n != stop {
+			t.Errorf("expected the walk to stop after %d routes, got %d", stop, n)
+		}
+	}
+}
+
+func TestHasRoute(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "/api/reports/{word:format?}", testHandler)
+	router.AddRoute("GET", "//{word:tenant}.example.com/stats", testHandler)
+	router.AddRoute("PUT", "/api/users/{uint:id}/posts", testHandler)
+
+	var tests = []struct {
+		Method string
+		Path   string
+		Has    bool
+	}{
+		{"GET", "/api/users/{uint:id}", true},
+		{"get", "/api/users/{uint:id}", true},
+		{"GET", "/api/users/{uint:id}/", true},
+		{"GET", "/api/users/5", false},
+		{"GET", "/api/users/{word:id}", false},
+		{"POST", "/api/users/{uint:id}", false},
+		{"GET", "/api/users", false},
+		{"PUT", "/api/users/{uint:id}", false},
+		{"PUT", "/api/users/{uint:id}/posts", true},
+		{"GET", "/api/reports/{word:format?}", true},
+		{"GET", "/api/reports/{word:format}", true},
+		{"GET", "/api/reports", true},
+		{"GET", "//{word:tenant}.example.com/stats", true},
+		{"GET", "//acme.example.com/stats", false},
+		{"GET", "/stats", false},
+	}
+	for _, tt := range tests {
+		if has := router.HasRoute(tt.Method, tt.Path); has != tt.Has {
+			t.Errorf("%s %s: expected HasRoute=%v", tt.Method, tt.Path, tt.Has)
+		}
+	}
+}
+
+func TestParamNames(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{uint:id}/trips/{date:from}/{date:to}", testHandler)
+	router.AddRoute("GET", "/cities/{geo:location}/@{word:name(3,)}", testHandler)
+	router.AddRoute("GET", "/formats/{re:(json|xml)}/{rei:(?P<lang>en|es)}", testHandler)
+	router.AddRoute("GET", "/reports/{enum:period:daily|weekly}/{word:format?}", testHandler)
+	router.AddRoute("GET", "//{word:tenant}.example.com/files/{path:rest}", testHandler)
+	router.AddRoute("GET", "/static/about", testHandler)
+
+	var tests = []struct {
+		Path  string
+		Names []string
+	}{
+		{"/api/users/{uint:id}/trips/{date:from}/{date:to}", []string{"id", "from", "to"}},
+		{"/cities/{geo:location}/@{word:name(3,)}", []string{"location", "name"}},
+		{"/formats/{re:(json|xml)}/{rei:(?P<lang>en|es)}", []string{"_1", "lang"}},
+		{"/reports/{enum:period:daily|weekly}/{word:format?}", []string{"period", "format"}},
+		{"//{word:tenant}.example.com/files/{path:rest}", []string{"tenant", "rest"}},
+		{"/static/about", nil},
+		{"/api/users/5", nil},
+	}
+	for _, tt := range tests {
+		names := router.ParamNames("GET", tt.Path)
+		if strings.Join(names, ",") != strings.Join(tt.Names, ",") {
+			t.Errorf("%s: expected names %q, got %q", tt.Path, tt.Names, names)
+		}
+	}
+}
+
+func TestOpenAPIPaths(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("PUT", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "/api/sessions/{uuid:sid}/@{word:name}", testHandler)
+	router.AddRoute("GET", "/api/events/{date:day}/{int:t(-40,85)}", testHandler)
+	router.AddRoute("GET", "/api/reports/{enum:period:daily|weekly}/{word:format?}", testHandler)
+	router.AddRoute("GET", "/api/formats/{re:(json|xml)}", testHandler)
+	router.AddRoute("GET", "/api/langs/{rei:(?P<lang>en|es)}", testHandler)
+	router.AddRoute("GET", "/api/prices/{float:amount}/{bool:tax}", testHandler)
+	router.AddRoute("GET", "//{word:tenant}.example.com/stats", testHandler)
+
+	type param struct{ Name, Type, Format string }
+	var tests = []struct {
+		Path   string
+		Method string
+		Params []param
+	}{
+		{"/api/users/{id}", "get", []param{{"id", "integer", ""}}},
+		{"/api/users/{id}", "put", []param{{"id", "integer", ""}}},
+		{"/api/sessions/{sid}/@{name}", "get", []param{{"sid", "string", "uuid"}, {"name", "string", ""}}},
+		{"/api/events/{day}/{t}", "get", []param{{"day", "string", "date"}, {"t", "integer", ""}}},
+		{"/api/reports/{period}", "get", []param{{"period", "string", ""}}},
+		{"/api/reports/{period}/{format}", "get", []param{{"period", "string", ""}, {"format", "string", ""}}},
+		{"/api/formats/{_1}", "get", []param{{"_1", "string", ""}}},
+		{"/api/langs/{lang}", "get", []param{{"lang", "string", ""}}},
+		{"/api/prices/{amount}/{tax}", "get", []param{{"amount", "number", ""}, {"tax", "boolean", ""}}},
+	}
+	paths := router.OpenAPIPaths()
+	if len(paths) != len(tests)-1 {
+		t.Errorf("expected %d paths, got %d", len(tests)-1, len(paths))
+	}
+	for _, tt := range tests {
+		op := paths[tt.Path][tt.Method]
+		if op == nil {
+			t.Errorf("%s %s: expected an operation", tt.Method, tt.Path)
+			continue
+		}
+		if len(op.Parameters) != len(tt.Params) {
+			t.Errorf("%s %s: expected %d parameters, got %d", tt.Method, tt.Path, len(tt.Params), len(op.Parameters))
+			continue
+		}
+		for i, p := range tt.Params {
+			got := op.Parameters[i]
+			if got.Name != p.Name || got.In != "path" || !got.Required || got.Schema.Type != p.Type || got.Schema.Format != p.Format {
+				t.Errorf("%s %s: expected parameter %v, got %+v", tt.Method, tt.Path, p, got)
+			}
+		}
+	}
+
+	if s := paths["/api/users/{id}"]["get"].Parameters[0].Schema; s.Minimum == nil || *s.Minimum != 0 || s.Maximum != nil {
+		t.Errorf("expected uint minimum 0, got %+v", s)
+	}
+	if s := paths["/api/events/{day}/{t}"]["get"].Parameters[1].Schema; s.Minimum == nil || *s.Minimum != -40 || s.Maximum == nil || *s.Maximum != 85 {
+		t.Errorf("expected int range -40..85, got %+v", s)
+	}
+	if s := paths["/api/reports/{period}"]["get"].Parameters[0].Schema; strings.Join(s.Enum, "|") != "daily|weekly" {
+		t.Errorf("expected enum values, got %+v", s)
+	}
+	if s := paths["/api/formats/{_1}"]["get"].Parameters[0].Schema; s.Pattern != "(json|xml)" {
+		t.Errorf("expected regexp pattern, got %+v", s)
+	}
+	if s := paths["/api/langs/{lang}"]["get"].Parameters[0].Schema; s.Pattern != "(?i)(?P<lang>en|es)" {
+		t.Errorf("expected case-insensitive regexp pattern, got %+v", s)
+	}
+	b, err := json.Marshal(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"/api/users/{id}":{"get":{"parameters":[{"name":"id","in":"path","required":true,"schema":{"type":"integer","minimum":0}}]`) {
+		t.Errorf("unexpected JSON %s", b)
+	}
+}
+
+func TestRoutePattern(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{uint:id}", testHandler)
+	router.AddRoute("GET", "/api/users/{word:name}", testHandler)
+	router.AddRoute("GET", "/api/reports/{word:format?}", testHandler)
+	router.AddRoute("GET", "/api/files/{path:rest}", testHandler)
+	router.AddRoute("GET", "//{word:tenant}.example.com/stats", testHandler)
+	router.AddRoute("get", "/", testHandler)
+
+	for path, pattern := range map[string]string{
+		"/api/users/5":             "/api/users/{uint:id}",
+		"/api/users/alice":         "/api/users/{word:name}",
+		"/api/reports":             "/api/reports/{word:format?}",
+		"/api/reports/csv":         "/api/reports/{word:format?}",
+		"/api/files/a/b.txt":       "/api/files/{path:rest}",
+		"//acme.example.com/stats": "//{word:tenant}.example.com/stats",
+		"/":                        "/",
+	} {
+		var values url.Values
+		if _, err := router.FindHandler("GET", path, &values); err != nil {
+			t.Errorf("%s: expected a match: %s", path, err.Error())
+			continue
+		}
+		if p := values.Get(RoutePattern); p != pattern {
+			t.Errorf("%s: expected the pattern %q, got %q", path, pattern, p)
+		}
+	}
+	var values url.Values
+	router.FindHandler("HEAD", "/api/users/5", &values)
+	if p := values.Get(RoutePattern); p != "/api/users/{uint:id}" {
+		t.Errorf("expected HEAD to report the GET pattern, got %q", p)
+	}
+}
+
+func TestConflictingRoutes(t *testing.T) {
+	var tests = []struct {
+		Routes   []string
+		Conflict bool
+	}{
+		{[]string{"/api/{word:a}/x", "/api/b/{word:c}"}, true},
+		{[]string{"/api/users/{uint:id}", "/api/users/{word:name}"}, true},
+		{[]string{"/api/users/{name}", "/api/users/me"}, true},
+		{[]string{"/api/users/*", "/api/users/me"}, true},
+		{[]string{"/files/{path:rest}", "/files/a/b"}, true},
+		{[]string{"/api/{word:v}", "/api/v1"}, true},
+		{[]string{"/api/users/{uint:id}", "/api/users/me"}, false},
+		{[]string{"/api/users/{uint:id}", "/api/users/{alpha:name}"}, false},
+		{[]string{"/api/{word:a}/x", "/api/b/y"}, false},
+		{[]string{"/api/users", "/api/posts"}, false},
+		{[]string{"/api/users/{uint:id}", "/api/users/{uint:id}/posts"}, false},
+		{[]string{"/api/reports/{word:format?}"}, false},
+		{[]string{"/api/{enum:kind:a|b}", "/api/{enum:kind:c|d}"}, false},
+		{[]string{"//{word:tenant}.example.com/{word:a}", "//{word:tenant}.example.com/b"}, true},
+	}
+	for _, tt := range tests {
+		router := newRouter()
+		for _, route := range tt.Routes {
+			router.AddRoute("GET", route, testHandler)
+		}
+		conflicts := router.ConflictingRoutes()
+		if (len(conflicts) > 0) != tt.Conflict {
+			t.Errorf("%q: expected conflict=%v, got %v", tt.Routes, tt.Conflict, conflicts)
+		}
+	}
+
+	router := newRouter()
+	router.AddRoute("GET", "/api/{word:a}/x", testHandler)
+	router.AddRoute("GET", "/api/b/{word:c}", testHandler)
+	router.AddRoute("PUT", "/api/b/x", testHandler)
+	conflicts := router.ConflictingRoutes()
+	if len(conflicts) != 1 {
+		t.Fatalf("expected one conflict, got %v", conflicts)
+	}
+	if c := conflicts[0]; c.Method != "GET" || c.Pattern != "/api/b/{word:c}" || c.Other != "/api/{word:a}/x" {
+		t.Errorf("unexpected conflict %+v", c)
+	}
+}
+
+func BenchmarkRegexpCacheParallel(b *testing.B) {
+	router := benchRouter()
+	exps := []string{"{uint:id}", "{uint:pid}", "{word:name}", "{date:day}"}
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if rx, _ := router.cache.load(exps[i%len(exps)]); rx == nil {
+				b.Fatal("expected a cached regexp")
+			}
+		}
+	})
+}
+
+func TestCombineRegexps(t *testing.T) {
+	routes := []string{
+		"/items/{item}",
+		"/items/{word:name}",
+		"/items/{uint:id}",
+		"/items/{uuid:uuid}",
+		"/items/{date:day}",
+		"/items/{enum:kind:new|used}",
+		"/items/{int:num}",
+		"/items/@{word:handle}",
+		"/items/{re:(a|ab)}/x",
+		"/items/{rei:(x|y)z}/r",
+		"/items/{uint:id}/parts/{word:part}",
+		"/items/{files:rest}",
+		"/items/static",
+		"/files/{path:rest}",
+		"/files/{word:name}/meta",
+	}
+	paths := []string{
+		"/items/123", "/items/-123", "/items/new", "/items/abc_1", "/items/2024-01-31",
+		"/items/123e4567-e89b-12d3-a456-426614174000", "/items/@bob", "/items/a b",
+		"/items/ab/x", "/items/a/x", "/items/5/parts/wheel", "/items/static",
+		"/files/a/b.txt", "/files/readme/meta", "/items/5/parts", "/items/XZ/r",
+	}
+	sequential, combined := newRouter(), newRouter()
+	combined.CombineRegexps = true
+	for _, route := range routes {
+		sequential.AddRoute("GET", route, testHandler)
+		combined.AddRoute("GET", route, testHandler)
+	}
+	if combined.root.findLink("GET").findLink("items").combined == nil {
+		t.Fatalf("expected the items links to be combined")
+	}
+	check := func() {
+		for _, path := range paths {
+			var sv, cv url.Values
+			_, serr := sequential.FindHandler("GET", path, &sv)
+			_, cerr := combined.FindHandler("GET", path, &cv)
+			if serr != cerr {
+				t.Errorf("%s: expected error %v, got %v", path, serr, cerr)
+				continue
+			}
+			if sv.Encode() != cv.Encode() {
+				t.Errorf("%s: expected values %v, got %v", path, sv, cv)
+			}
+		}
+	}
+	check()
+
+	sequential.DeleteRoute("GET", "/items/{uint:id}")
+	combined.DeleteRoute("GET", "/items/{uint:id}")
+	sequential.AddRoute("GET", "/items/{hex:code}", testHandler)
+	combined.AddRoute("GET", "/items/{hex:code}", testHandler)
+	check()
+	frozen := combined.Freeze()
+	for _, path := range paths {
+		_, err := combined.FindHandler("GET", path, nil)
+		if _, ferr := frozen.FindHandler("GET", path, nil); ferr != err {
+			t.Errorf("%s: expected frozen error %v, got %v", path, err, ferr)
+		}
+	}
+}
+
+func benchSiblings(b *testing.B, combine bool) {
+	router := newRouter()
+	router.CombineRegexps = combine
+	for i := 0; i < 50; i++ {
+		router.AddRoute("GET", "/items/{enum:k"+strconv.Itoa(i)+":v"+strconv.Itoa(i)+"}", testHandler)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := router.FindHandler("GET", "/items/v"+strconv.Itoa(i%50), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMixedSiblings matches the PSE siblings of a node, added with the
+// catch-all first; the specific ones are tried first.
+func BenchmarkMixedSiblings(b *testing.B) {
+	router := newRouter()
+	for _, path := range []string{"/items/{name}", "/items/{word:slug}", "/items/{date:day}", "/items/{uint:id}", "/items/{uuid:uuid}"} {
+		router.AddRoute("GET", path, testHandler)
+	}
+	paths := []string{"/items/123", "/items/2024-01-31", "/items/hello", "/items/a b"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := router.FindHandler("GET", paths[i%len(paths)], nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSiblingsSequential(b *testing.B) {
+	benchSiblings(b, false)
+}
+
+func BenchmarkSiblingsCombined(b *testing.B) {
+	benchSiblings(b, true)
+}
+
+func BenchmarkStaticRoute(b *testing.B) {
+	router := benchRouter()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := router.FindHandler("GET", "/static/css/site.css", nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWideNode(b *testing.B) {
+	router := newRouter()
+	for i := 0; i < 1000; i++ {
+		router.AddRoute("GET", "/items/e"+strconv.Itoa(i), testHandler)
+	}
+	router.AddRoute("GET", "/items/{uint:id}", testHandler)
+	paths := make([]string, 1000)
+	for i := range paths {
+		paths[i] = "/items/e" + strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := router.FindHandler("GET", paths[i%len(paths)], nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindHandlerNoValues(b *testing.B) {
+	router := benchRouter()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := router.FindHandler("GET", benchPaths[i%len(benchPaths)], nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPathMethods(b *testing.B) {
+	router := benchRouter()
+	router.AddRoute("PUT", "/api/users/{uint:id}", testHandler)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		router.PathMethodsSlice(benchPaths[i%len(benchPaths)])
+	}
+}
+
+func benchPathValues(b *testing.B, pooled bool) {
+	router := newRouter()
+	router.AddRoute("GET", "/api/users/{uint:id}/posts/{word:slug}", testHandler)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var values url.Values
+		if pooled {
+			values = pathValuesPool.Get().(url.Values)
+		}
+		if _, err := router.FindHandler("GET", "/api/users/123/posts/hello", &values); err != nil {
+			b.Fatal(err)
+		}
+		if pooled {
+			for k := range values {
+				delete(values, k)
+			}
+			pathValuesPool.Put(values)
+		}
+	}
+}
+
+func BenchmarkPathValues(b *testing.B) {
+	benchPathValues(b, false)
+}
+
+func BenchmarkPathValuesPooled(b *testing.B) {
+	benchPathValues(b, true)
+}
+
+func BenchmarkMatchSegment(b *testing.B) {
+	router := newRouter()
+	router.AddRoute("GET", "/events/{date:when}", testHandler)
+	node := router.root.findLink("GET").findLink("events")
+	values := make(url.Values)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if node.matchSegment(router.cache, "2024-01-31", &values) == nil {
+			b.Fatal("expected a match")
+		}
+		for k := range values {
+			delete(values, k)
+		}
+	}
+}
+
+func TestPathValuesReuse(t *testing.T) {
+	svc := NewService("/")
+	var got url.Values
+	handler := func(ctx *Context) {
+		got = make(url.Values)
+		for k, v := range ctx.PathValues {
+			got[k] = v
+		}
+	}
+	svc.Router().AddRoute("GET", "/posts/{uint:id}", handler)
+	svc.Router().AddRoute("GET", "/tags/{word:tag}", handler)
+	for _, test := range []struct{ Path, Name, Value, Stale string }{
+		{"/posts/5", "id", "5", "tag"},
+		{"/tags/go", "tag", "go", "id"},
+		{"/posts/7", "id", "7", "tag"},
+	} {
+		got = nil
+		svc.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", test.Path, nil))
+		if got.Get(test.Name) != test.Value {
+			t.Errorf("%s: expected %s=%q, got %v", test.Path, test.Name, test.Value, got)
+		}
+		if _, ok := got[test.Stale]; ok {
+			t.Errorf("%s: unexpected value of a previous request %v", test.Path, got)
+		}
+	}
+}
+
+// cloneFilter runs the handler on a clone of the context, as the gzip and
+// etag filters do.
+type cloneFilter struct{}
+
+func (cloneFilter) Run(next HandlerFunc) HandlerFunc {
+	return func(ctx *Context) {
+		next(ctx.Clone(ctx.ResponseWriter))
+	}
+}
+
+func TestPathValuesClone(t *testing.T) {
+	svc := NewService("/")
+	svc.Use(cloneFilter{})
+	var seen url.Values
+	svc.Router().AddRoute("GET", "/posts/{uint:id}", func(ctx *Context) {
+		if ctx.PathValues.Get("id") != "5" {
+			t.Errorf("expected id=5, got %v", ctx.PathValues)
+		}
+		seen = ctx.PathValues
+	})
+	svc.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/posts/5", nil))
+	if seen == nil {
+		t.Fatal("expected the handler to run")
+	}
+	// the map was freed with the request's context, and cleared.
+	if len(seen) != 0 {
+		t.Errorf("expected the path values to be freed, got %v", seen)
+	}
+}
+
+func TestRouterHandler(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/posts/{uint:id}", func(ctx *Context) {
+		ctx.Respond(map[string]string{"id": ctx.PathValues.Get("id"), "ctx": PathValue(ctx.Request, "id")})
+	})
+	h := &RouterHandler{Router: router}
+
+	var tests = []struct {
+		Method, Path string
+		Code         int
+		Body, Allow  string
+	}{
+		{"GET", "/posts/5", http.StatusOK, `{"ctx":"5","id":"5"}`, ""},
+		{"GET", "/posts/five", http.StatusNotFound, "", ""},
+		{"GET", "/tags", http.StatusNotFound, "", ""},
+		{"DELETE", "/posts/5", http.StatusMethodNotAllowed, "", "GET, HEAD"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.Method, test.Path, nil))
+		if w.Code != test.Code {
+			t.Errorf("%s %s: expected status %d, got %d", test.Method, test.Path, test.Code, w.Code)
+		}
+		if test.Body != "" && strings.TrimSpace(w.Body.String()) != test.Body {
+			t.Errorf("%s %s: expected body %s, got %s", test.Method, test.Path, test.Body, w.Body.String())
+		}
+		if allow := w.Header().Get("Allow"); allow != test.Allow {
+			t.Errorf("%s %s: expected Allow %q, got %q", test.Method, test.Path, test.Allow, allow)
+		}
+		if test.Code != http.StatusOK {
+			var e StatusError
+			if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Code != test.Code {
+				t.Errorf("%s %s: expected a status error %d, got %s", test.Method, test.Path, test.Code, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestRequestPathValues(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/users/{word:username}/posts/{uint:id}", testHandler)
+
+	var got url.Values
+	var name, missing string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = PathValues(r)
+		name, missing = PathValue(r, "username"), PathValue(r, "nothing")
+	})
+	r := httptest.NewRequest("GET", "/users/alice/posts/7", nil)
+	if PathValues(r) != nil || PathValue(r, "username") != "" {
+		t.Errorf("expected no path values before matching, got %v", PathValues(r))
+	}
+	values := make(url.Values)
+	if _, err := router.FindHandler(r.Method, r.URL.Path, &values); err != nil {
+		t.Fatal(err)
+	}
+	next.ServeHTTP(httptest.NewRecorder(), WithPathValues(r, values))
+	if name != "alice" || missing != "" {
+		t.Errorf("expected username=alice and no value for nothing, got %q and %q", name, missing)
+	}
+	if got.Get("id") != "7" || got.Get("_2") != "7" {
+		t.Errorf("expected id=7 by name and index, got %v", got)
+	}
+
+	// middleware of RouterHandler routes reads them too, while serving.
+	var tag string
+	router.AddRoute("GET", "/tags/{word:tag}", func(ctx *Context) {
+		tag = PathValue(ctx.Request, "tag")
+	})
+	(&RouterHandler{Router: router}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tags/go", nil))
+	if tag != "go" {
+		t.Errorf("expected tag=go in the request context, got %q", tag)
+	}
+}
+
+func TestServeMuxPattern(t *testing.T) {
+	var tests = []struct {
+		Pattern, Method, Path string
+		Err                   bool
+	}{
+		{"GET /users/{id}", "GET", "/users/{id}", false},
+		{"POST\t/users/{id}/posts", "POST", "/users/{id}/posts", false},
+		{"/files/{name...}", "", "/files/{path:name}", false},
+		{"GET /{$}", "GET", "/", false},
+		{"GET /posts/{$}", "GET", "/posts", false},
+		{"GET example.com/users/{id}/files/{file...}", "GET", "//example.com/users/{id}/files/{path:file}", false},
+		{"GET /", "", "", true},
+		{"GET /static/", "", "", true},
+		{"GET /users/@{id}", "", "", true},
+		{"GET /files/{name...}/edit", "", "", true},
+		{"GET /users/{user id}", "", "", true},
+		{"GET /files/*", "", "", true},
+		{"GET users", "", "", true},
+	}
+	for _, test := range tests {
+		method, path, err := ServeMuxPattern(test.Pattern)
+		if test.Err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s %s", test.Pattern, method, path)
+			}
+			continue
+		}
+		if err != nil || method != test.Method || path != test.Path {
+			t.Errorf("%s: expected %q %q, got %q %q %v", test.Pattern, test.Method, test.Path, method, path, err)
+		}
+	}
+
+	router := newRouter()
+	AddServeMuxRoute(router, "GET /users/{id}", testHandler)
+	AddServeMuxRoute(router, "/files/{name...}", testHandler)
+	for _, test := range []struct{ Method, Path, Name, Value string }{
+		{"GET", "/users/alice", "id", "alice"},
+		{"PUT", "/files/docs/a.txt", "name", "docs/a.txt"},
+		{"DELETE", "/files/b.txt", "name", "b.txt"},
+	} {
+		values := make(url.Values)
+		if _, err := router.FindHandler(test.Method, test.Path, &values); err != nil {
+			t.Errorf("%s %s: expected a match: %s", test.Method, test.Path, err.Error())
+			continue
+		}
+		if values.Get(test.Name) != test.Value {
+			t.Errorf("%s %s: expected %s=%q, got %v", test.Method, test.Path, test.Name, test.Value, values)
+		}
+	}
+	if _, err := router.FindHandler("POST", "/users/alice", nil); err == nil {
+		t.Errorf("expected POST not to match a GET pattern")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic with a subtree pattern")
+		}
+	}()
+	AddServeMuxRoute(router, "GET /static/", testHandler)
+}
+
+func TestParams(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/users/{word:name}/posts/{uint:id}", testHandler)
+	router.AddRoute("GET", "/pairs/{word:b}/{word:a}", testHandler)
+	router.AddRoute("GET", "/months/{re:([0][1-9]|[1][0-2])}/{word:day}", testHandler)
+	router.AddRoute("GET", "/reports/{uint:id}/{word:format=json}", testHandler)
+	router.AddRoute("GET", "/counts/{re:(\\d+)}/{uint:id}", testHandler)
+	router.AddRoute("GET", "//{word:tenant}.example.com/files/{uint:id}/{word:tab?}", testHandler)
+
+	var tests = []struct {
+		Path   string
+		Params Params
+	}{
+		{"/users/alice/posts/7", Params{{"name", "alice"}, {"id", "7"}}},
+		{"/pairs/x/y", Params{{"b", "x"}, {"a", "y"}}},
+		{"/pairs/x/x", Params{{"b", "x"}, {"a", "x"}}},
+		{"/counts/5/5", Params{{"_1", "5"}, {"id", "5"}}},
+		{"//acme.example.com/files/5", Params{{"tenant", "acme"}, {"id", "5"}}},
+		{"//acme.example.com/files/5/acme", Params{{"tenant", "acme"}, {"id", "5"}, {"tab", "acme"}}},
+		{"/months/04/mon", Params{{"_1", "04"}, {"day", "mon"}}},
+		{"/reports/5", Params{{"id", "5"}, {"format", "json"}}},
+	}
+	for _, test := range tests {
+		values := make(url.Values)
+		if _, err := router.FindHandler("GET", test.Path, &values); err != nil {
+			t.Fatalf("%s: expected a match: %s", test.Path, err.Error())
+		}
+		ps := NewParams(values)
+		if len(ps) != len(test.Params) {
+			t.Errorf("%s: expected %v, got %v", test.Path, test.Params, ps)
+			continue
+		}
+		for i := range ps {
+			if ps[i] != test.Params[i] {
+				t.Errorf("%s: expected %v, got %v", test.Path, test.Params, ps)
+				break
+			}
+			if v := ps.ByName(ps[i].Key); v != ps[i].Value {
+				t.Errorf("%s: expected ByName(%q) to be %q, got %q", test.Path, ps[i].Key, ps[i].Value, v)
+			}
+		}
+		if v := ps.ByName("missing"); v != "" {
+			t.Errorf("%s: expected no value for a missing name, got %q", test.Path, v)
+		}
+		if v := ps.ByName(RoutePattern); v != "" {
+			t.Errorf("%s: expected no route pattern param, got %q", test.Path, v)
+		}
+	}
+	if v := NewParams(nil).ByName("id"); v != "" {
+		t.Errorf("expected no value in empty params, got %q", v)
+	}
+}
+
+func TestMount(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "css"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "css", "app.css"), []byte("body{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got *http.Request
+	var id string
+	router := newRouter()
+	router.AddRoute("GET", "/static/index", testHandler)
+	router.Mount("/static/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		http.FileServer(http.Dir(dir)).ServeHTTP(w, r)
+	}))
+	router.Mount("/users/{uint:id}/admin", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, id = r, PathValue(r, "id")
+	}))
+	h := &RouterHandler{Router: router}
+
+	var tests = []struct {
+		Method, Path string
+		Code         int
+		Mounted      string
+	}{
+		{"GET", "/static/css/app.css", http.StatusOK, "/css/app.css"},
+		{"HEAD", "/static/css/app.css", http.StatusOK, "/css/app.css"},
+		{"GET", "/static/css/none.css", http.StatusNotFound, "/css/none.css"},
+		{"DELETE", "/users/5/admin/posts/7", http.StatusOK, "/posts/7"},
+		{"POST", "/users/5/admin", http.StatusOK, "/"},
+		{"GET", "/static/index", http.StatusOK, ""},
+		{"GET", "/users/five/admin", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		got = nil
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.Method, test.Path, nil))
+		if w.Code != test.Code {
+			t.Errorf("%s %s: expected status %d, got %d", test.Method, test.Path, test.Code, w.Code)
+		}
+		if test.Mounted == "" {
+			if got != nil {
+				t.Errorf("%s %s: expected not to reach the mounted handler, got %s", test.Method, test.Path, got.URL.Path)
+			}
+			continue
+		}
+		if got == nil || got.URL.Path != test.Mounted || got.Method != test.Method {
+			t.Errorf("%s %s: expected the mounted handler with %q, got %v", test.Method, test.Path, test.Mounted, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/static/css/app.css", nil))
+	if w.Body.String() != "body{}" {
+		t.Errorf("expected the mounted file, got %q", w.Body.String())
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/5/admin/x", nil))
+	if id != "5" {
+		t.Errorf("expected id=5 of the prefix in the mounted request, got %q", id)
+	}
+}
+
+func TestTypedPathValues(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/int/{int:v}", testHandler)
+	router.AddRoute("GET", "/uint/{uint:v}", testHandler)
+	router.AddRoute("GET", "/float/{float:v}", testHandler)
+	router.AddRoute("GET", "/uuid/{uuid:v}", testHandler)
+	router.AddRoute("GET", "/date/{date:v}", testHandler)
+	router.AddRoute("GET", "/word/{word:v}", testHandler)
+	get := func(path string) url.Values {
+		values := make(url.Values)
+		if _, err := router.FindHandler("GET", path, &values); err != nil {
+			t.Fatalf("%s: expected a match: %s", path, err.Error())
+		}
+		return values
+	}
+
+	for _, test := range []struct {
+		Path string
+		Want int64
+		Err  bool
+	}{
+		{"/int/-42", -42, false},
+		{"/int/+999999999999999999", 999999999999999999, false},
+		{"/uint/9223372036854775808", 0, true},
+		{"/word/abc", 0, true},
+	} {
+		n, err := GetInt(get(test.Path), "v")
+		if (err != nil) != test.Err || n != test.Want {
+			t.Errorf("GetInt %s: expected %d (error %v), got %d %v", test.Path, test.Want, test.Err, n, err)
+		}
+	}
+	if n, err := GetUint(get("/uint/18446744073709551615"), "v"); err != nil || n != 18446744073709551615 {
+		t.Errorf("GetUint: expected the max uint64, got %d %v", n, err)
+	}
+	if _, err := GetUint(get("/uint/18446744073709551616"), "v"); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("GetUint: expected an overflow error, got %v", err)
+	}
+	if f, err := GetFloat(get("/float/-3.5"), "v"); err != nil || f != -3.5 {
+		t.Errorf("GetFloat: expected -3.5, got %v %v", f, err)
+	}
+	want := [16]byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	for _, path := range []string{"/uuid/123e4567-e89b-12d3-a456-426614174000", "/uuid/123E4567E89B12D3A456426614174000"} {
+		if b, err := GetUUID(get(path), "v"); err != nil || b != want {
+			t.Errorf("GetUUID %s: expected %x, got %x %v", path, want, b, err)
+		}
+	}
+	if _, err := GetUUID(get("/word/abc"), "v"); err == nil {
+		t.Errorf("GetUUID: expected an error for a word")
+	}
+
+	for _, test := range []struct {
+		Path string
+		Want string
+		Err  bool
+	}{
+		{"/date/2024", "2024-01-01", false},
+		{"/date/2024-05", "2024-05-01", false},
+		{"/date/2024-05-17", "2024-05-17", false},
+		{"/date/20240517", "2024-05-17", false},
+		{"/date/2024-05-17T10:30Z", "2024-05-17", false},
+		{"/date/2024-02-29", "2024-02-29", false},
+		{"/date/2023-02-29", "", true},
+		{"/word/abc", "", true},
+	} {
+		d, err := GetDate(get(test.Path), "v")
+		if test.Err {
+			if err == nil {
+				t.Errorf("GetDate %s: expected an error, got %v", test.Path, d)
+			}
+			continue
+		}
+		if err != nil || d.Format("2006-01-02") != test.Want || d.Location() != time.UTC || d.Hour() != 0 {
+			t.Errorf("GetDate %s: expected %s, got %v %v", test.Path, test.Want, d, err)
+		}
+	}
+
+	for _, get := range []func(url.Values, string) error{
+		func(v url.Values, name string) error { _, err := GetInt(v, name); return err },
+		func(v url.Values, name string) error { _, err := GetUint(v, name); return err },
+		func(v url.Values, name string) error { _, err := GetFloat(v, name); return err },
+		func(v url.Values, name string) error { _, err := GetUUID(v, name); return err },
+		func(v url.Values, name string) error { _, err := GetDate(v, name); return err },
+	} {
+		if err := get(nil, "v"); err == nil || !strings.Contains(err.Error(), "no path value") {
+			t.Errorf("expected an error for a missing value, got %v", err)
+		}
+	}
+}
+
+func TestGetGeo(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/places/{geo:loc}", testHandler)
+	router.AddRoute("GET", "/words/{word:loc}", testHandler)
+	float := func(f float64) *float64 { return &f }
+
+	var tests = []struct {
+		Path string
+		Geo  GeoPoint
+		Err  bool
+	}{
+		{"/places/48.2,16.3", GeoPoint{Latitude: 48.2, Longitude: 16.3}, false},
+		{"/places/-33.9,151.2,25.5", GeoPoint{Latitude: -33.9, Longitude: 151.2, Altitude: float(25.5)}, false},
+		{"/places/48.2,16.3;u=40", GeoPoint{Latitude: 48.2, Longitude: 16.3, Uncertainty: float(40)}, false},
+		{"/places/48.2,16.3,183;u=0.5", GeoPoint{Latitude: 48.2, Longitude: 16.3, Altitude: float(183), Uncertainty: float(0.5)}, false},
+		{"/places/48.2,16.3;crs=wgs84", GeoPoint{Latitude: 48.2, Longitude: 16.3, CRS: "wgs84"}, false},
+		{"/places/48.2,16.3;crs=wgs84;u=12", GeoPoint{Latitude: 48.2, Longitude: 16.3, CRS: "wgs84", Uncertainty: float(12)}, false},
+		{"/words/vienna", GeoPoint{}, true},
+	}
+	same := func(a, b *float64) bool { return a == nil && b == nil || a != nil && b != nil && *a == *b }
+	for _, test := range tests {
+		values := make(url.Values)
+		if _, err := router.FindHandler("GET", test.Path, &values); err != nil {
+			t.Fatalf("%s: expected a match: %s", test.Path, err.Error())
+		}
+		geo, err := GetGeo(values, "loc")
+		if test.Err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", test.Path, geo)
+			}
+			continue
+		}
+		if err != nil || geo.Latitude != test.Geo.Latitude || geo.Longitude != test.Geo.Longitude ||
+			!same(geo.Altitude, test.Geo.Altitude) || !same(geo.Uncertainty, test.Geo.Uncertainty) || geo.CRS != test.Geo.CRS {
+			t.Errorf("%s: expected %+v, got %+v %v", test.Path, test.Geo, geo, err)
+		}
+	}
+	if _, err := GetGeo(nil, "loc"); err == nil {
+		t.Errorf("expected an error for a missing value")
+	}
+}
+
+func TestAddRouteErr(t *testing.T) {
+	var tests = []struct {
+		Method, Path string
+		Err          string
+	}{
+		{"GET", "/users/{uint:id}", ""},
+		{"GET", "/users/{int:t(-40,85)}/temp", ""},
+		{"GET", "//{word:tenant}.example.com/stats", ""},
+		{"GET", "/files/{path:name}", ""},
+		{"GET", "/reports/{uint:id}/{word:format=json}", ""},
+		{"", "/users", "invalid route method"},
+		{"GET", "users", "must begin with"},
+		{"GET", "/users/{int:t(85,-40)}", "PSE range is not valid"},
+		{"GET", "/users/{word:name(6,3)}", "PSE length is not valid"},
+		{"GET", "/users/{uuid:id:9}", "uuid version"},
+		{"GET", "/users/{enum:kind:}", "PSE enum"},
+		{"GET", "/users/{re:([a-z}", "invalid path segment"},
+		{"GET", "//{word:t(9,1)}.example.com/stats", "PSE length is not valid"},
+		{"GET", "/files/{path:name}/edit", "must be the last path segment"},
+		{"GET", "/users/{word:name?}/posts", "must be the last path segment"},
+	}
+	for _, test := range tests {
+		router := newRouter()
+		err := router.AddRouteErr(test.Method, test.Path, testHandler)
+		if test.Err == "" {
+			if err != nil {
+				t.Errorf("%s %s: expected no error, got %s", test.Method, test.Path, err.Error())
+			} else if len(router.ListRoutes()) == 0 {
+				t.Errorf("%s %s: expected the route to be added", test.Method, test.Path)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s %s: expected an error with %q", test.Method, test.Path, test.Err)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "relax: ") || !strings.Contains(err.Error(), test.Err) {
+			t.Errorf("%s %s: expected an error with %q, got %s", test.Method, test.Path, test.Err, err.Error())
+		}
+		if routes := router.ListRoutes(); len(routes) != 0 || router.hosts != nil && len(router.hosts.links) != 0 {
+			t.Errorf("%s %s: expected the router not to change, got %v", test.Method, test.Path, routes)
+		}
+		func() {
+			defer func() {
+				if r := recover(); r == nil || r != err.Error() {
+					t.Errorf("%s %s: expected AddRoute to panic with %q, got %v", test.Method, test.Path, err.Error(), r)
+				}
+			}()
+			router.AddRoute(test.Method, test.Path, testHandler)
+		}()
+	}
+
+	frozen := newRouter().Freeze().(*TrieRegexpRouter)
+	if err := frozen.AddRouteErr("GET", "/users", testHandler); err == nil {
+		t.Errorf("expected an error adding a route to a frozen router")
+	}
+}
+
+func TestNewRouter(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("GET", "/users/{uint:id}", testHandler)
+	values := make(url.Values)
+	if _, err := router.FindHandler("GET", "/users/5", &values); err != nil || values.Get("id") != "5" {
+		t.Errorf("expected the route to match with id=5, got %v %v", values, err)
+	}
+	if _, err := router.FindHandler("GET", "/users/five", nil); err != ErrRouteNotFound {
+		t.Errorf("expected no match, got %v", err)
+	}
+	if methods := router.PathMethods("/users/5"); methods != "GET, HEAD" {
+		t.Errorf("expected the methods GET, HEAD, got %q", methods)
+	}
+	if _, err := NewRouter().FindHandler("GET", "/users/5", nil); err != ErrRouteNotFound {
+		t.Errorf("expected a new router without routes, got %v", err)
+	}
+
+	if err := router.AddRouteErr("GET", "/posts/{int:n(9,1)}", testHandler); err == nil {
+		t.Errorf("expected an error for an invalid route")
+	}
+	w := httptest.NewRecorder()
+	(&RouterHandler{Router: router}).ServeHTTP(w, httptest.NewRequest("GET", "/users/7", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 from RouterHandler, got %d", w.Code)
+	}
+}
+
+// unregisterType removes the registered PSE type 'name' when the test ends, so
+// the tests can run repeatedly.
+func unregisterType(t *testing.T, name string) {
+	t.Cleanup(func() {
+		pseTypesMu.Lock()
+		defer pseTypesMu.Unlock()
+		delete(pseTypes, name)
+	})
+}
+
+func TestRegisterType(t *testing.T) {
+	unregisterType(t, "sku")
+	if err := RegisterType("sku", `[A-Z]{3}-\d{4}`); err != nil {
+		t.Fatalf("expected sku to be registered: %s", err.Error())
+	}
+	for _, test := range []struct{ Name, Pattern string }{
+		{"sku", `\d+`},
+		{"word", `\w+`},
+		{"path", `.+`},
+		{"bad name", `\d+`},
+		{"", `\d+`},
+		{"broken", `[a-z`},
+	} {
+		if err := RegisterType(test.Name, test.Pattern); err == nil || !strings.HasPrefix(err.Error(), "relax: ") {
+			t.Errorf("RegisterType(%q, %q): expected an error, got %v", test.Name, test.Pattern, err)
+		}
+	}
+
+	router := newRouter()
+	router.AddRoute("GET", "/products/{sku:code}", testHandler)
+	router.AddRoute("GET", "/products/{sku:code}/parts/{sku:part}", testHandler)
+	router.AddRoute("GET", "/products/{word:name}/reviews", testHandler)
+	router.AddRoute("GET", "/orders/#{sku:code}", testHandler)
+	var tests = []struct {
+		Path   string
+		Values url.Values
+	}{
+		{"/products/ABC-1234", url.Values{"code": {"ABC-1234"}}},
+		{"/products/ABC-1234/parts/XYZ-0001", url.Values{"code": {"ABC-1234"}, "part": {"XYZ-0001"}}},
+		{"/products/abc/reviews", url.Values{"name": {"abc"}}},
+		{"/orders/%23ABC-1234", url.Values{"code": {"ABC-1234"}}},
+		{"/products/abc-1234", nil},
+		{"/products/ABC-12345", nil},
+	}
+	for _, test := range tests {
+		values := make(url.Values)
+		_, err := router.FindHandler("GET", test.Path, &values)
+		if test.Values == nil {
+			if err == nil {
+				t.Errorf("%s: expected no match, got %v", test.Path, values)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+			continue
+		}
+		for name := range test.Values {
+			if values.Get(name) != test.Values.Get(name) {
+				t.Errorf("%s: expected %s=%q, got %v", test.Path, name, test.Values.Get(name), values)
+			}
+		}
+	}
+
+	// types are registered while other routers add routes.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		unregisterType(t, "sku"+strconv.Itoa(i))
+		go func(i int) {
+			defer wg.Done()
+			RegisterType("sku"+strconv.Itoa(i), `\d{6}`)
+		}(i)
+		go func() {
+			defer wg.Done()
+			newRouter().AddRoute("GET", "/products/{sku:code}", testHandler)
+		}()
+	}
+	wg.Wait()
+}
+
+// luhn returns true if 's' is a number with a valid Luhn check digit.
+func luhn(s string) bool {
+	if len(s) < 2 {
+		return false
+	}
+	sum := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		d := int(s[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if (len(s)-i)%2 == 0 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func TestRegisterMatcher(t *testing.T) {
+	unregisterType(t, "card")
+	unregisterType(t, "pan")
+	if err := RegisterMatcher("card", luhn); err != nil {
+		t.Fatalf("expected card to be registered: %s", err.Error())
+	}
+	if err := RegisterType("pan", `\d{13,19}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMatcher("pan", luhn); err != nil {
+		t.Fatalf("expected a matcher for pan: %s", err.Error())
+	}
+	for _, name := range []string{"card", "pan", "uint", "path", "bad name"} {
+		if err := RegisterMatcher(name, luhn); err == nil {
+			t.Errorf("RegisterMatcher(%q): expected an error", name)
+		}
+	}
+	if err := RegisterMatcher("nilcard", nil); err == nil {
+		t.Errorf("expected an error for a nil matcher")
+	}
+
+	router := newRouter()
+	router.AddRoute("GET", "/cards/{card:num}", testHandler)
+	router.AddRoute("GET", "/cards/{other}", testHandler)
+	router.AddRoute("GET", "/pans/{pan:num}/charge", testHandler)
+	router.AddRoute("GET", "/pans/{code}/charge", testHandler)
+	router.AddRoute("GET", "/pay/card-{card:num}", testHandler)
+	var tests = []struct {
+		Path, Name, Value string
+	}{
+		{"/cards/4111111111111111", "num", "4111111111111111"},
+		{"/cards/79927398713", "num", "79927398713"},
+		{"/cards/4111111111111112", "other", "4111111111111112"},
+		{"/cards/visa", "other", "visa"},
+		{"/pans/4111111111111111/charge", "num", "4111111111111111"},
+		{"/pans/4111111111111112/charge", "code", "4111111111111112"},
+		{"/pans/79927398713/charge", "code", "79927398713"},
+		{"/pay/card-79927398713", "num", "79927398713"},
+		{"/pay/card-79927398710", "", ""},
+	}
+	for _, test := range tests {
+		values := make(url.Values)
+		_, err := router.FindHandler("GET", test.Path, &values)
+		if test.Name == "" {
+			if err == nil {
+				t.Errorf("%s: expected no match, got %v", test.Path, values)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected a match: %s", test.Path, err.Error())
+			continue
+		}
+		if values.Get(test.Name) != test.Value {
+			t.Errorf("%s: expected %s=%q, got %v", test.Path, test.Name, test.Value, values)
+		}
+	}
+
+	// a matcher added after the routes of its type is checked too.
+	unregisterType(t, "acct")
+	if err := RegisterType("acct", `\d+`); err != nil {
+		t.Fatal(err)
+	}
+	router.AddRoute("GET", "/accts/{acct:num}", testHandler)
+	if _, err := router.FindHandler("GET", "/accts/79927398710", nil); err != nil {
+		t.Errorf("expected a match without a matcher: %s", err.Error())
+	}
+	if err := RegisterMatcher("acct", luhn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := router.FindHandler("GET", "/accts/79927398710", nil); err != ErrRouteNotFound {
+		t.Errorf("expected the matcher to be checked, got %v", err)
+	}
+	if _, err := router.FindHandler("GET", "/accts/79927398713", nil); err != nil {
+		t.Errorf("expected a match: %s", err.Error())
+	}
+}
+
+func TestMaxSegmentLength(t *testing.T) {
+	router := newRouter()
+	router.MaxSegmentLength = 16
+	router.AddRoute("GET", "/users/{word:name}", testHandler)
+	router.AddRoute("GET", "/slow/{re:(a+)+b}", testHandler)
+	router.AddRoute("GET", "/files/{path:name}", testHandler)
+
+	var tests = []struct {
+		Path  string
+		Match bool
+	}{
+		{"/users/" + strings.Repeat("a", 16), true},
+		{"/users/" + strings.Repeat("a", 17), false},
+		{"/slow/aaaab", true},
+		{"/slow/" + strings.Repeat("a", 1<<20) + "!", false},
+		{"/files/" + strings.Repeat("abcdefgh/", 100), true},
+		{"/files/" + strings.Repeat("abcdefgh/", 100) + strings.Repeat("x", 17), false},
+		{"//" + strings.Repeat("h", 17) + "/users/bob", false},
+	}
+	for _, test := range tests {
+		start := time.Now()
+		_, err := router.FindHandler("GET", test.Path, nil)
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%.20s...: expected to be done in a second, took %s", test.Path, d)
+		}
+		if test.Match && err != nil {
+			t.Errorf("%.40s: expected a match: %s", test.Path, err.Error())
+		}
+		if !test.Match && err != ErrRouteNotFound {
+			t.Errorf("%.40s: expected ErrRouteNotFound, got %v", test.Path, err)
+		}
+	}
+
+	// the limit is kept by clones, and the not found handler is returned.
+	long := "/users/" + strings.Repeat("a", 17)
+	if _, err := router.Clone().FindHandler("GET", long, nil); err != ErrRouteNotFound {
+		t.Errorf("expected the clone to keep the limit, got %v", err)
+	}
+	var notFound bool
+	router.SetNotFoundHandler(func(ctx *Context) { notFound = true })
+	if h, err := router.FindHandler("GET", long, nil); err != nil || h == nil {
+		t.Errorf("expected the not found handler for a long segment, got %v", err)
+	} else if h(nil); !notFound {
+		t.Errorf("expected the not found handler for a long segment")
+	}
+	router.SetNotFoundHandler(nil)
+	router.MaxSegmentLength = 0
+	if _, err := router.FindHandler("GET", long, nil); err != nil {
+		t.Errorf("expected no limit, got %v", err)
+	}
+}
+
+func TestMaxPathLength(t *testing.T) {
+	router := newRouter()
+	router.MaxPathLength = 20
+	router.MaxSegments = 3
+	router.AddRoute("GET", "/{path:name}", testHandler)
+	router.AddRoute("GET", "//example.com/{path:name}", testHandler)
+
+	var tests = []struct {
+		Path string
+		Err  error
+	}{
+		{"/" + strings.Repeat("a", 19), nil},
+		{"/" + strings.Repeat("a", 20), ErrRouteTooLong},
+		{"/aa/bb/cc", nil},
+		{"/aa/bb/cc/", nil},
+		{"/aa/bb/cc/dd", ErrRouteTooLong},
+		{"//example.com/aa/bb", nil},
+		{"//example.com/a/b/c", nil},
+		{"//example.com/a/b/c/d", ErrRouteTooLong},
+		{"//example.com/abcdefg", ErrRouteTooLong},
+	}
+	for _, test := range tests {
+		_, err := router.FindHandler("GET", test.Path, nil)
+		if err != test.Err {
+			t.Errorf("%s: expected %v, got %v", test.Path, test.Err, err)
+		}
+	}
+
+	svc := NewService("/")
+	svc.Router().(*TrieRegexpRouter).MaxPathLength = 10
+	svc.Router().AddRoute("GET", "/{path:name}", testHandler)
+	w := httptest.NewRecorder()
+	svc.ServeHTTP(w, httptest.NewRequest("GET", "/"+strings.Repeat("a", 10), nil))
+	if w.Code != http.StatusRequestURITooLong {
+		t.Errorf("expected status 414, got %d", w.Code)
+	}
+}
+
+func TestControlCharacters(t *testing.T) {
+	router := newRouter()
+	router.AddRoute("GET", "/files/{path:name}", testHandler)
+	router.AddRoute("GET", "/users/{name}", testHandler)
+
+	var tests = []struct {
+		Path string
+		Err  error
+	}{
+		{"/files/a/b.txt", nil},
+		{"/users/john%20doe", nil},
+		{"/users/%C3%A9mile", nil},
+		{"/users/100%", nil},
+		{"/users/50%2", nil},
+		{"/users/a%7e", nil},
+		{"/files/a\x00.txt", ErrRouteBadPath},
+		{"/files/a%00.txt", ErrRouteBadPath},
+		{"/users/bob%0A", ErrRouteBadPath},
+		{"/users/bob%0d%0aSet-Cookie:x", ErrRouteBadPath},
+		{"/users/bob\t", ErrRouteBadPath},
+		{"/users/bob%7F", ErrRouteBadPath},
+		{"/users/bob\x1b[31m", ErrRouteBadPath},
+	}
+	for _, test := range tests {
+		_, err := router.FindHandler("GET", test.Path, nil)
+		if err != test.Err {
+			t.Errorf("%q: expected %v, got %v", test.Path, test.Err, err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	(&RouterHandler{Router: router}).ServeHTTP(w, httptest.NewRequest("GET", "/files/a%00.txt", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestCleanDotSegments(t *testing.T) {
+	for _, test := range []struct{ Path, Clean string }{
+		{"/api/files/a/../b", "/api/files/b"},
+		{"/api/files/./b", "/api/files/b"},
+		{"/api/files/a/%2e%2E/b", "/api/files/b"},
+		{"/api/files/a/.%2e/b", "/api/files/b"},
+		{"/api/files/%2E/b/", "/api/files/b/"},
+		{"/api/..", "/"},
+		{"/../../etc/passwd", "/etc/passwd"},
+		{"/a/b/..", "/a"},
+		{"/a/.hidden/b..c/...", "/a/.hidden/b..c/..."},
+		{"/a/%2e%2ex", "/a/%2e%2ex"},
+		{"a/../b", "b"},
+		{"/", "/"},
+	} {
+		if clean := cleanDots(test.Path); clean != test.Clean {
+			t.Errorf("%s: expected %q, got %q", test.Path, test.Clean, clean)
+		}
+	}
+
+	router := newRouter()
+	router.AddRoute("GET", "/api/files/{path:name}", testHandler)
+	router.AddRoute("GET", "/api/users", testHandler)
+	values := make(url.Values)
+	if _, err := router.FindHandler("GET", "/api/files/a/../b", &values); err != nil || values.Get("name") != "a/../b" {
+		t.Errorf("expected the dot segments to be captured as is, got %v %v", values, err)
+	}
+
+	router.CleanDotSegments = true
+	for _, test := range []struct{ Path, Name string }{
+		{"/api/files/a/../b", "b"},
+		{"/api/files/a/%2e%2e/b/./c", "b/c"},
+		{"/api/files/x/../../files/c", "c"},
+		{"/api/files/a/.hidden", "a/.hidden"},
+	} {
+		values := make(url.Values)
+		if _, err := router.FindHandler("GET", test.Path, &values); err != nil || values.Get("name") != test.Name {
+			t.Errorf("%s: expected name=%q, got %v %v", test.Path, test.Name, values, err)
+		}
+	}
+	if _, err := router.FindHandler("GET", "/api/files/a/..", nil); err != ErrRouteNotFound {
+		t.Errorf("expected a path cleaned to /api/files not to match, got %v", err)
+	}
+	if _, err := router.FindHandler("GET", "/api/files/../users", nil); err != nil {
+		t.Errorf("expected the clean path /api/users to match, got %v", err)
+	}
+
+	router.RedirectCleanPath = true
+	_, err := router.FindHandler("GET", "/api/files/a/../b", nil)
+	if e, ok := err.(*StatusError); !ok || e.Code != http.StatusMovedPermanently || e.Details != "/api/files/b" {
+		t.Errorf("expected a redirect to /api/files/b, got %v", err)
+	}
+}
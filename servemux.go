@@ -0,0 +1,110 @@
+// Copyright 2014-present Codehack. All rights reserved.
+// For mobile and web development visit http://codehack.com
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package relax
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// serveMuxMethods are the methods of a ServeMux pattern without a method.
+// HEAD and OPTIONS are answered by the router.
+var serveMuxMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// serveMuxName matches the name of a ServeMux wildcard, as a PSE varname.
+var serveMuxName = regexp.MustCompile(`^\w+$`)
+
+/*
+ServeMuxPattern translates a pattern of net/http's ServeMux, as of Go 1.22,
+"[METHOD ][HOST]/[PATH]", into the method and path of a route. The method is ""
+if the pattern has none. The wildcards of the path become PSE's:
+
+	{name}    -> {name}       catch-all; matches a whole segment.
+	{name...} -> {path:name}  matches the remaining path; must be last.
+	{$}       -> (removed)    routes match the whole path already.
+
+For example, "GET example.com/users/{id}/files/{file...}" is the method "GET"
+and the path "//example.com/users/{id}/files/{path:file}".
+
+Not all patterns translate, an error is returned for:
+
+  - A path ending in "/" without {$}, which matches a subtree in ServeMux but
+    only the path itself in a route, since trailing slashes are ignored. Use a
+    {name...} wildcard instead; e.g., "/static/{file...}".
+  - A wildcard that is not a whole segment, or with a name that is not a PSE
+    varname; ServeMux rejects the former too.
+  - A "*" in the path, which a route takes as a wildcard.
+
+Some behaviors differ still: a {name...} wildcard must match at least one
+segment, while ServeMux matches an empty rest; ServeMux picks the most specific
+of two patterns, while the router ranks PSE's as described in Router; and
+ServeMux redirects paths to clean them, while the router cleans them silently.
+*/
+func ServeMuxPattern(pattern string) (method, path string, err error) {
+	rest := pattern
+	if i := strings.IndexAny(rest, " \t"); i != -1 {
+		method, rest = rest[:i], strings.TrimLeft(rest[i+1:], " \t")
+	}
+	i := strings.Index(rest, "/")
+	if i == -1 {
+		return "", "", fmt.Errorf("relax: ServeMux pattern %q has no path", pattern)
+	}
+	host, segs := rest[:i], strings.Split(rest[i+1:], "/")
+	if last := len(segs) - 1; segs[last] == "{$}" {
+		segs = segs[:last]
+	} else if segs[last] == "" {
+		return "", "", fmt.Errorf("relax: ServeMux pattern %q matches a subtree, end it with a {name...} wildcard or {$}", pattern)
+	}
+	for i, seg := range segs {
+		if strings.Contains(seg, "*") {
+			return "", "", fmt.Errorf("relax: ServeMux pattern %q has a \"*\"", pattern)
+		}
+		if !strings.ContainsAny(seg, "{}") {
+			continue
+		}
+		if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+			return "", "", fmt.Errorf("relax: ServeMux wildcard %q must be a whole segment in %q", seg, pattern)
+		}
+		name := seg[1 : len(seg)-1]
+		if strings.HasSuffix(name, "...") {
+			if i != len(segs)-1 {
+				return "", "", fmt.Errorf("relax: ServeMux wildcard %q must be the last segment in %q", seg, pattern)
+			}
+			name = "path:" + strings.TrimSuffix(name, "...")
+		}
+		if !serveMuxName.MatchString(strings.TrimPrefix(name, "path:")) {
+			return "", "", fmt.Errorf("relax: invalid ServeMux wildcard %q in %q", seg, pattern)
+		}
+		segs[i] = "{" + name + "}"
+	}
+	path = "/" + strings.Join(segs, "/")
+	if host != "" {
+		path = "//" + host + path
+	}
+	return method, path, nil
+}
+
+// AddServeMuxRoute adds the route of a ServeMux pattern to 'router', as
+// translated by ServeMuxPattern, to reuse the patterns of a net/http service.
+// A pattern without a method is added for GET, POST, PUT, PATCH and DELETE.
+// This function will panic if the pattern can't be translated.
+//
+//	relax.AddServeMuxRoute(router, "GET /users/{id}", handler)
+//	// same as router.AddRoute("GET", "/users/{id}", handler)
+func AddServeMuxRoute(router Router, pattern string, handler HandlerFunc) {
+	method, path, err := ServeMuxPattern(pattern)
+	if err != nil {
+		panic(err.Error())
+	}
+	if method != "" {
+		router.AddRoute(method, path, handler)
+		return
+	}
+	for _, method := range serveMuxMethods {
+		router.AddRoute(method, path, handler)
+	}
+}
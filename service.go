@@ -7,6 +7,7 @@ package relax
 
 import (
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -102,18 +103,52 @@ func InternalServerError(w http.ResponseWriter, r *http.Request) {
 // dispatch tries to connect the request to a resource handler. If it can't find
 // an appropriate handler it will return an HTTP error response.
 func (svc *Service) dispatch(ctx *Context) {
-	handler, err := svc.router.FindHandler(ctx.Request.Method, ctx.Request.URL.Path, &ctx.PathValues)
+	path := routePath(svc.router, ctx.Request)
+	handler, err := svc.router.FindHandler(ctx.Request.Method, path, &ctx.PathValues)
 	if err != nil {
-		ctx.Header().Set("Cache-Control", "max-age=300, stale-if-error=600")
-		if err == ErrRouteBadMethod { // 405-Method Not Allowed
-			ctx.Header().Set("Allow", svc.router.PathMethods(ctx.Request.URL.Path))
-		}
-		ctx.Error(err.(*StatusError).Code, err.Error(), err.(*StatusError).Details)
+		routeError(ctx, svc.router, path, err)
 		return
 	}
 	handler(ctx)
 }
 
+// routePath returns the path of the request 'r' to match in 'router', escaped;
+// with the request host, without the port, if the router has host routes.
+func routePath(router Router, r *http.Request) string {
+	path := r.URL.EscapedPath()
+	if router, ok := router.(*TrieRegexpRouter); ok && router.hasHosts() {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		path = "//" + host + path
+	}
+	return path
+}
+
+// routeError responds to the request of 'ctx' with the error 'err' returned
+// by the router's FindHandler for 'path'. A 405 response has the Allow header
+// with the path methods, and a redirect has the Location header.
+func routeError(ctx *Context, router Router, path string, err error) {
+	ctx.Header().Set("Cache-Control", "max-age=300, stale-if-error=600")
+	switch e := err.(*StatusError); e.Code {
+	case http.StatusMethodNotAllowed:
+		allow, ok := e.Details.(string)
+		if !ok {
+			allow = router.PathMethods(path)
+		}
+		ctx.Header().Set("Allow", allow)
+	case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		if location, ok := e.Details.(string); ok {
+			if ctx.Request.URL.RawQuery != "" {
+				location += "?" + ctx.Request.URL.RawQuery
+			}
+			ctx.Header().Set("Location", location)
+		}
+	}
+	ctx.Error(err.(*StatusError).Code, err.Error(), err.(*StatusError).Details)
+}
+
 /*
 Adapter creates a new request context, sets default HTTP headers, creates the
 link-chain of service filters, then passes the request to content negotiation.
@@ -147,6 +182,9 @@ func (svc *Service) Adapter() http.HandlerFunc {
 
 		ctx := newContext(parent, w, r)
 		defer ctx.free()
+		// the map is taken by the context that is freed, the clones of filters
+		// share it.
+		ctx.PathValues = pathValuesPool.Get().(url.Values)
 
 		requestID := NewRequestID(r.Header.Get("Request-Id"))
 